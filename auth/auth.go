@@ -2,36 +2,62 @@ package auth
 
 import (
 	"context"
-	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/go-errors/errors"
+	"github.com/jeffguorg/middlewares/problem"
 )
 
+var (
+	ErrUnauthenticated  = errors.New("authentication required")
+	ErrCheckUserMissing = errors.New("CheckUser is not installed in front of the handler")
+)
+
+// CheckUserCookie authenticates users with the JWT in the "user" cookie
 func CheckUserCookie(key interface{}, method jwt.SigningMethod) func(next http.Handler) http.Handler {
+	return CheckUserToken(key, method, FromCookie("user"))
+}
+
+// CheckUserToken authenticates users with the JWT found by the first of
+// extractors that finds one, e.g. FromCookie("user") then
+// FromAuthorizationHeader(). Claims of a valid token are returned by GetUser,
+// requests without a valid token go on anonymously.
+func CheckUserToken(key interface{}, method jwt.SigningMethod, extractors ...TokenExtractor) func(next http.Handler) http.Handler {
+	return CheckUser(key, method, Options{Extractors: extractors})
+}
+
+// CheckUser authenticates users like CheckUserToken, validating claims and
+// issuing tokens in SetUser according to options
+func CheckUser(key interface{}, method jwt.SigningMethod, options Options) func(next http.Handler) http.Handler {
+	extractors := options.Extractors
+	if len(extractors) == 0 {
+		extractors = []TokenExtractor{FromCookie("user")}
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ctx := context.WithValue(r.Context(), "user.key", key)
 			ctx = context.WithValue(ctx, "user.method", method)
+			ctx = context.WithValue(ctx, "user.options", options)
 
-			userCookie, err := r.Cookie("user")
-			if err != nil {
-				next.ServeHTTP(w, r.WithContext(ctx))
-				return
+			var claims jwt.Claims
+			var err error = ErrUnauthenticated
+			if tokenString, ok := extractToken(r, extractors); ok {
+				claims, err = options.parse(tokenString, key, method)
 			}
-			token, err := jwt.Parse(userCookie.Value, func(token *jwt.Token) (interface{}, error) {
-				if token.Method != method {
-					return nil, fmt.Errorf("Wrong signing method. Expecting %v, got %v", method.Alg(), token.Method.Alg())
+			if options.Refresh != nil && options.Refresh.needsRenewal(claims, err, options.now()) {
+				if renewed, renewErr := options.Refresh.renew(w, r, key, method, options); renewErr == nil {
+					claims, err = renewed, nil
 				}
-				return key, nil
-			})
+			}
 			if err != nil {
 				next.ServeHTTP(w, r.WithContext(ctx))
 				return
 			}
 
-			ctx = context.WithValue(r.Context(), "user", token.Claims)
+			ctx = context.WithValue(ctx, "user", claims)
 
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
@@ -41,7 +67,7 @@ func CheckUserCookie(key interface{}, method jwt.SigningMethod) func(next http.H
 func MustUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if GetUser(r) == nil {
-			w.WriteHeader(http.StatusUnauthorized)
+			problem.Respond(w, r, http.StatusUnauthorized, ErrUnauthenticated)
 			return
 		}
 		next.ServeHTTP(w, r)
@@ -52,49 +78,77 @@ func GetKey(r *http.Request) interface{} {
 	return r.Context().Value("user.key")
 }
 
+// GetUser returns the claims of the authenticated user as a map, struct
+// claims are converted through their JSON form
 func GetUser(r *http.Request) map[string]interface{} {
 	v := r.Context().Value("user")
 	if v == nil {
 		return nil
 	}
-	return v.(jwt.MapClaims)
+	user, err := claimsMap(v)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+// GetClaims returns the claims of the authenticated user as decoded, i.e. the
+// value returned by Options.NewClaims
+func GetClaims(r *http.Request) jwt.Claims {
+	claims, _ := r.Context().Value("user").(jwt.Claims)
+	return claims
 }
 
 func UnsetUser(w http.ResponseWriter) {
 	http.SetCookie(w, &http.Cookie{
 		Name:   "user",
+		Path:   "/",
 		MaxAge: -1,
 	})
 }
 
+// SetUser issues a token for user in the "user" cookie. Missing sub, iat,
+// exp, iss and aud claims are filled from the options given to CheckUser,
+// which may also enable a refresh token. Nothing is issued when CheckUser is
+// not installed in front of the handler.
 func SetUser(w http.ResponseWriter, r *http.Request, user map[string]interface{}) {
+	_ = issueUser(w, r, user)
+}
+
+// issueUser is SetUser reporting why no token was issued
+func issueUser(w http.ResponseWriter, r *http.Request, user map[string]interface{}) error {
 	key := r.Context().Value("user.key")
-	method := r.Context().Value("user.method")
+	signMethod, ok := r.Context().Value("user.method").(jwt.SigningMethod)
+	if key == nil || !ok {
+		return ErrCheckUserMissing
+	}
 
-	if key == nil || method == nil {
-		return
+	options := getOptions(r)
+	str, err := options.issue(user, key, signMethod)
+	if err != nil {
+		return err
 	}
+	setUserCookie(w, str)
+	if options.Refresh != nil {
+		return options.Refresh.issue(w, copyUser(user), "", time.Time{}, options.now())
+	}
+	return nil
+}
 
-	if signMethod, ok := method.(jwt.SigningMethod); ok {
-		token := jwt.New(signMethod)
-		for k, v := range map[string]interface{}{
-			"sub": "backend",
-			"iat": float64(time.Now().Unix()),
-			"exp": float64(time.Now().Add(time.Hour).Unix()),
-		} {
-			if _, ok := user[k]; !ok {
-				user[k] = v
-			}
-		}
-		token.Claims = jwt.MapClaims(user)
-		str, err := token.SignedString(key)
-		if err != nil {
-			return
-		}
-		http.SetCookie(w, &http.Cookie{
-			Name:  "user",
-			Value: str,
-			Path:  "/",
-		})
+func setUserCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:  "user",
+		Value: token,
+		Path:  "/",
+	})
+}
+
+// SetClaims is SetUser for struct claims
+func SetClaims(w http.ResponseWriter, r *http.Request, claims jwt.Claims) error {
+	user, err := claimsMap(claims)
+	if err != nil {
+		return err
 	}
+	SetUser(w, r, user)
+	return nil
 }
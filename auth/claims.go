@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-errors/errors"
+)
+
+const (
+	defaultSubject  = "backend"
+	defaultLifetime = time.Hour
+)
+
+var (
+	ErrClaimMissing = errors.New("required claim is missing")
+	ErrClaimInvalid = errors.New("claim is invalid")
+	ErrTokenExpired = errors.New("token has expired")
+	ErrTokenEarly   = errors.New("token is not valid yet")
+)
+
+// Options controls how user tokens are validated and issued. The zero value
+// keeps the historical behavior: signature and time claims are checked, and
+// SetUser issues tokens for subject "backend" lasting one hour.
+type Options struct {
+	// Issuer is required as iss when parsing and set when issuing
+	Issuer string
+
+	// Audience must be one of the aud values when parsing and is set when
+	// issuing
+	Audience string
+
+	// ClockSkew is tolerated on exp, nbf and iat
+	ClockSkew time.Duration
+
+	// RequiredClaims must be present in every accepted token, e.g. "sub"
+	// or "exp"
+	RequiredClaims []string
+
+	// NewClaims returns the value tokens are decoded into, such as a
+	// pointer to a struct embedding jwt.StandardClaims. jwt.MapClaims when
+	// nil. The claims are returned by GetClaims.
+	NewClaims func() jwt.Claims
+
+	// Subject is the default sub of issued tokens, "backend" when empty
+	Subject string
+
+	// Lifetime is the default validity of issued tokens, one hour when 0
+	Lifetime time.Duration
+
+	// Refresh enables refresh tokens and sliding expiration, see
+	// RefreshOptions
+	Refresh *RefreshOptions
+
+	// Extractors find the token in requests, the "user" cookie when empty
+	Extractors []TokenExtractor
+
+	// Now returns the current time, time.Now when nil
+	Now func() time.Time
+}
+
+func (options Options) now() time.Time {
+	if options.Now != nil {
+		return options.Now()
+	}
+	return time.Now()
+}
+
+// Validate checks the time claims, iss, aud and required claims
+func (options Options) Validate(claims map[string]interface{}) error {
+	for _, name := range options.RequiredClaims {
+		if _, ok := claims[name]; !ok {
+			return fmt.Errorf("%w: %s", ErrClaimMissing, name)
+		}
+	}
+
+	now := options.now()
+	skew := options.ClockSkew
+	if exp, ok, err := numericDate(claims, "exp"); err != nil {
+		return err
+	} else if ok && now.After(exp.Add(skew)) {
+		return ErrTokenExpired
+	}
+	if nbf, ok, err := numericDate(claims, "nbf"); err != nil {
+		return err
+	} else if ok && now.Before(nbf.Add(-skew)) {
+		return ErrTokenEarly
+	}
+	if iat, ok, err := numericDate(claims, "iat"); err != nil {
+		return err
+	} else if ok && now.Before(iat.Add(-skew)) {
+		return ErrTokenEarly
+	}
+
+	if options.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != options.Issuer {
+			return fmt.Errorf("%w: iss", ErrClaimInvalid)
+		}
+	}
+	if options.Audience != "" && !hasAudience(claims["aud"], options.Audience) {
+		return fmt.Errorf("%w: aud", ErrClaimInvalid)
+	}
+	return nil
+}
+
+func numericDate(claims map[string]interface{}, name string) (time.Time, bool, error) {
+	value, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	var seconds float64
+	switch v := value.(type) {
+	case float64:
+		seconds = v
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("%w: %s", ErrClaimInvalid, name)
+		}
+		seconds = f
+	default:
+		return time.Time{}, false, fmt.Errorf("%w: %s", ErrClaimInvalid, name)
+	}
+	return time.Unix(int64(seconds), 0), true, nil
+}
+
+func hasAudience(aud interface{}, expected string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == expected
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == expected {
+				return true
+			}
+		}
+	case []string:
+		for _, s := range v {
+			if s == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// claimsMap returns claims as a map, struct claims go through their JSON form
+func claimsMap(claims interface{}) (map[string]interface{}, error) {
+	if m, ok := claims.(jwt.MapClaims); ok {
+		return m, nil
+	}
+	if m, ok := claims.(map[string]interface{}); ok {
+		return m, nil
+	}
+	content, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(content, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// parse verifies the signature of tokenString with key and method and
+// validates its claims
+func (options Options) parse(tokenString string, key interface{}, method jwt.SigningMethod) (jwt.Claims, error) {
+	return options.parseWithKeyFunc(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != method {
+			return nil, fmt.Errorf("Wrong signing method. Expecting %v, got %v", method.Alg(), token.Method.Alg())
+		}
+		return key, nil
+	})
+}
+
+func (options Options) parseWithKeyFunc(tokenString string, keyFunc jwt.Keyfunc) (jwt.Claims, error) {
+	var claims jwt.Claims = jwt.MapClaims{}
+	if options.NewClaims != nil {
+		claims = options.NewClaims()
+	}
+	// time claims are checked by Validate with the configured skew
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(tokenString, claims, keyFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := claimsMap(token.Claims)
+	if err != nil {
+		return nil, err
+	}
+	if err := options.Validate(m); err != nil {
+		return nil, err
+	}
+	return token.Claims, nil
+}
+
+// issue fills the default claims of user and signs it
+func (options Options) issue(user map[string]interface{}, key interface{}, method jwt.SigningMethod) (string, error) {
+	now := options.now()
+	subject := options.Subject
+	if subject == "" {
+		subject = defaultSubject
+	}
+	lifetime := options.Lifetime
+	if lifetime == 0 {
+		lifetime = defaultLifetime
+	}
+
+	defaults := map[string]interface{}{
+		"sub": subject,
+		"iat": float64(now.Unix()),
+		"exp": float64(now.Add(lifetime).Unix()),
+	}
+	if options.Issuer != "" {
+		defaults["iss"] = options.Issuer
+	}
+	if options.Audience != "" {
+		defaults["aud"] = options.Audience
+	}
+	for k, v := range defaults {
+		if _, ok := user[k]; !ok {
+			user[k] = v
+		}
+	}
+
+	token := jwt.New(method)
+	token.Claims = jwt.MapClaims(user)
+	return token.SignedString(key)
+}
+
+func getOptions(r *http.Request) Options {
+	options, _ := r.Context().Value("user.options").(Options)
+	return options
+}
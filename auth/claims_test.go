@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	options := Options{
+		Issuer:         "https://issuer.example",
+		Audience:       "api",
+		ClockSkew:      time.Minute,
+		RequiredClaims: []string{"sub"},
+		Now:            func() time.Time { return now },
+	}
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss": "https://issuer.example",
+			"aud": []interface{}{"web", "api"},
+			"sub": "jane",
+			"iat": float64(now.Unix()),
+			"exp": float64(now.Add(time.Hour).Unix()),
+		}
+	}
+	if err := options.Validate(valid()); err != nil {
+		t.Fatalf("valid claims rejected: %v", err)
+	}
+
+	skewed := valid()
+	skewed["exp"] = float64(now.Add(-30 * time.Second).Unix())
+	if err := options.Validate(skewed); err != nil {
+		t.Errorf("token expired within the clock skew rejected: %v", err)
+	}
+
+	cases := map[string]struct {
+		mutate func(map[string]interface{})
+		err    error
+	}{
+		"missing sub": {func(c map[string]interface{}) { delete(c, "sub") }, ErrClaimMissing},
+		"issuer":      {func(c map[string]interface{}) { c["iss"] = "https://evil.example" }, ErrClaimInvalid},
+		"audience":    {func(c map[string]interface{}) { c["aud"] = "other" }, ErrClaimInvalid},
+		"exp type":    {func(c map[string]interface{}) { c["exp"] = "tomorrow" }, ErrClaimInvalid},
+		"expired":     {func(c map[string]interface{}) { c["exp"] = float64(now.Add(-2 * time.Minute).Unix()) }, ErrTokenExpired},
+		"not before":  {func(c map[string]interface{}) { c["nbf"] = float64(now.Add(2 * time.Minute).Unix()) }, ErrTokenEarly},
+		"issued late": {func(c map[string]interface{}) { c["iat"] = float64(now.Add(2 * time.Minute).Unix()) }, ErrTokenEarly},
+	}
+	for name, c := range cases {
+		claims := valid()
+		c.mutate(claims)
+		if err := options.Validate(claims); !errors.Is(err, c.err) {
+			t.Errorf("%s: %v, want %v", name, err, c.err)
+		}
+	}
+}
+
+func TestCheckUserIssuesAndParses(t *testing.T) {
+	key := []byte("secret")
+	options := Options{Issuer: "https://app.example", Audience: "app", RequiredClaims: []string{"sub", "exp"}}
+	middleware := CheckUser(key, jwt.SigningMethodHS256, options)
+
+	var cookie *http.Cookie
+	login := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetUser(w, r, map[string]interface{}{"sub": "jane"})
+	}))
+	w := httptest.NewRecorder()
+	login.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/login", nil))
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "user" {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatal("no user cookie")
+	}
+
+	var user map[string]interface{}
+	show := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = GetUser(r)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookie)
+	show.ServeHTTP(httptest.NewRecorder(), r)
+	if user == nil || user["sub"] != "jane" || user["iss"] != "https://app.example" {
+		t.Fatalf("user %v", user)
+	}
+
+	// a token of another issuer signed with the same key is rejected
+	forged := signToken(t, jwt.SigningMethodHS256, "", key, jwt.MapClaims{
+		"sub": "jane", "iss": "https://other.example", "aud": "app", "exp": time.Now().Add(time.Hour).Unix(),
+	})
+	user = nil
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: "user", Value: forged})
+	show.ServeHTTP(httptest.NewRecorder(), r)
+	if user != nil {
+		t.Errorf("token of another issuer accepted: %v", user)
+	}
+
+	// as is one signed with another key
+	forged = signToken(t, jwt.SigningMethodHS256, "", []byte("guess"), jwt.MapClaims{
+		"sub": "jane", "iss": "https://app.example", "aud": "app", "exp": time.Now().Add(time.Hour).Unix(),
+	})
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: "user", Value: forged})
+	show.ServeHTTP(httptest.NewRecorder(), r)
+	if user != nil {
+		t.Errorf("token signed with another key accepted: %v", user)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"github.com/go-errors/errors"
+	"github.com/jeffguorg/middlewares/problem"
 	"net/http"
 )
 
@@ -36,13 +37,13 @@ func SecureCookie(key []byte, queryname, cookiename string) func(http.Handler) h
 			orig := r.URL.Query().Get(queryname)
 			cookie, err := r.Cookie(cookiename)
 			if err != nil {
-				w.WriteHeader(http.StatusBadRequest)
+				problem.Respond(w, r, http.StatusBadRequest, ErrCSRF)
 				return
 			}
 
 			sign := digest(key, []byte(orig))
 			if cookie.Value != sign {
-				w.WriteHeader(http.StatusBadRequest)
+				problem.Respond(w, r, http.StatusBadRequest, ErrCSRF)
 				return
 			}
 
@@ -0,0 +1,336 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-errors/errors"
+	"github.com/jeffguorg/middlewares/signature"
+)
+
+const (
+	defaultJWKSCacheTTL        = time.Hour
+	defaultJWKSRefreshInterval = time.Minute
+	defaultJWKSTimeout         = 10 * time.Second
+)
+
+var (
+	ErrKeyNotFound        = errors.New("no key matches the token")
+	ErrAlgorithmForbidden = errors.New("token algorithm does not match the key")
+)
+
+type jwksKey struct {
+	alg string
+	key interface{}
+}
+
+// JWKS is a JSON Web Key Set fetched from a URL, or read from a file, and
+// cached. Unknown key ids trigger a refresh, at most once per
+// RefreshInterval, so keys rotated by the provider are picked up without
+// letting bogus tokens hammer it.
+type JWKS struct {
+	// URL or File locates the set, URL wins when both are set
+	URL  string
+	File string
+
+	// Client fetches URL, a client with a 10 seconds timeout when nil
+	Client *http.Client
+
+	// CacheTTL is how long the set is used before it is fetched again, one
+	// hour when 0
+	CacheTTL time.Duration
+
+	// RefreshInterval is the minimum time between two fetches, one minute
+	// when 0
+	RefreshInterval time.Duration
+
+	// Now returns the current time, time.Now when nil
+	Now func() time.Time
+
+	// mu guards the fields below and is never held while fetching
+	mu        sync.Mutex
+	keys      map[string]jwksKey
+	err       error // of the last fetch
+	fetchedAt time.Time
+	triedAt   time.Time
+	fetching  chan struct{} // closed when the fetch in flight ends
+}
+
+// NewJWKS returns a set fetched from url
+func NewJWKS(url string) *JWKS {
+	return &JWKS{URL: url}
+}
+
+// NewJWKSFromFile returns a set read from a local file
+func NewJWKSFromFile(path string) *JWKS {
+	return &JWKS{File: path}
+}
+
+func (set *JWKS) load() ([]byte, error) {
+	if set.URL == "" {
+		return ioutil.ReadFile(set.File)
+	}
+
+	client := set.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultJWKSTimeout}
+	}
+	resp, err := client.Get(set.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", set.URL, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (set *JWKS) now() time.Time {
+	if set.Now != nil {
+		return set.Now()
+	}
+	return time.Now()
+}
+
+// Refresh fetches the set now, or waits for the fetch in flight. The cached
+// keys are kept when it fails.
+func (set *JWKS) Refresh() error {
+	set.mu.Lock()
+	done := set.fetching
+	if done == nil {
+		done = set.startFetch()
+	}
+	set.mu.Unlock()
+
+	<-done
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	return set.err
+}
+
+// startFetch fetches the set in the background and returns a channel closed
+// once it is done. set.mu must be held.
+func (set *JWKS) startFetch() chan struct{} {
+	done := make(chan struct{})
+	set.fetching = done
+	set.triedAt = set.now()
+	triedAt := set.triedAt
+
+	go func() {
+		keys, err := set.fetch()
+
+		set.mu.Lock()
+		set.err = err
+		if err == nil {
+			set.keys = keys
+			set.fetchedAt = triedAt
+		}
+		set.fetching = nil
+		set.mu.Unlock()
+		close(done)
+	}()
+	return done
+}
+
+func (set *JWKS) fetch() (map[string]jwksKey, error) {
+	content, err := set.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var document struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]jwksKey, len(document.Keys))
+	for _, raw := range document.Keys {
+		jwk, err := signature.ParseJWK(raw)
+		if err != nil || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		// keys of unsupported types are skipped rather than failing the set
+		public, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = jwksKey{alg: jwk.Alg, key: public}
+	}
+	return keys, nil
+}
+
+// lookup returns the key for kid. A stale set is refreshed in the background
+// while its keys keep being used, an unknown kid waits for a refresh.
+// Concurrent lookups share a single fetch.
+func (set *JWKS) lookup(kid string) (jwksKey, error) {
+	ttl, interval := set.CacheTTL, set.RefreshInterval
+	if ttl == 0 {
+		ttl = defaultJWKSCacheTTL
+	}
+	if interval == 0 {
+		interval = defaultJWKSRefreshInterval
+	}
+
+	set.mu.Lock()
+	now := set.now()
+	key, ok := set.find(kid)
+	due := set.fetching == nil && now.Sub(set.triedAt) >= interval
+	if ok {
+		if due && now.Sub(set.fetchedAt) > ttl {
+			set.startFetch()
+		}
+		set.mu.Unlock()
+		return key, nil
+	}
+
+	done := set.fetching
+	if done == nil && due {
+		done = set.startFetch()
+	}
+	set.mu.Unlock()
+	if done == nil {
+		return jwksKey{}, fmt.Errorf("%w: kid %q", ErrKeyNotFound, kid)
+	}
+
+	<-done
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	if set.keys == nil && set.err != nil {
+		return jwksKey{}, set.err
+	}
+	if key, ok := set.find(kid); ok {
+		return key, nil
+	}
+	return jwksKey{}, fmt.Errorf("%w: kid %q", ErrKeyNotFound, kid)
+}
+
+// find looks kid up, a token without kid matches a set holding a single key
+func (set *JWKS) find(kid string) (jwksKey, bool) {
+	if kid == "" && len(set.keys) == 1 {
+		for _, key := range set.keys {
+			return key, true
+		}
+	}
+	key, ok := set.keys[kid]
+	return key, ok
+}
+
+// Keyfunc returns the public key matching the kid of token, for jwt.Parse.
+// The token algorithm must suit the key type, and equal the alg of the key
+// when it has one, so symmetric or none algorithms are never accepted.
+func (set *JWKS) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, err := set.lookup(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	alg := token.Method.Alg()
+	if key.alg != "" && key.alg != alg {
+		return nil, ErrAlgorithmForbidden
+	}
+	switch key.key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") && !strings.HasPrefix(alg, "PS") {
+			return nil, ErrAlgorithmForbidden
+		}
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return nil, ErrAlgorithmForbidden
+		}
+	default:
+		return nil, ErrAlgorithmForbidden
+	}
+	return key.key, nil
+}
+
+// Audience is the aud claim, a single string or an array
+type Audience []string
+
+// UnmarshalJSON accepts both forms of aud
+func (aud *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*aud = Audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*aud = list
+	return nil
+}
+
+// OIDCClaims holds the registered claims of ID and access tokens and the
+// standard OpenID Connect profile claims. Use it as Options.NewClaims to read
+// them with GetClaims.
+type OIDCClaims struct {
+	Issuer          string   `json:"iss,omitempty"`
+	Subject         string   `json:"sub,omitempty"`
+	Audience        Audience `json:"aud,omitempty"`
+	ExpiresAt       int64    `json:"exp,omitempty"`
+	NotBefore       int64    `json:"nbf,omitempty"`
+	IssuedAt        int64    `json:"iat,omitempty"`
+	AuthTime        int64    `json:"auth_time,omitempty"`
+	Nonce           string   `json:"nonce,omitempty"`
+	AuthorizedParty string   `json:"azp,omitempty"`
+
+	Name              string `json:"name,omitempty"`
+	GivenName         string `json:"given_name,omitempty"`
+	FamilyName        string `json:"family_name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     bool   `json:"email_verified,omitempty"`
+	Picture           string `json:"picture,omitempty"`
+	Locale            string `json:"locale,omitempty"`
+}
+
+// Valid implements jwt.Claims, validation is done by Options
+func (claims *OIDCClaims) Valid() error {
+	return nil
+}
+
+// CheckOIDCToken authenticates users with tokens issued by an OpenID Connect
+// provider and signed by one of keys. options.Issuer must be set to the
+// provider and options.Audience to the client id, otherwise tokens issued to
+// any client of the provider would pass; it panics when either is empty.
+// Tokens are read from the Authorization header unless options.Extractors
+// says otherwise, their claims are returned by GetUser.
+func CheckOIDCToken(keys *JWKS, options Options) func(next http.Handler) http.Handler {
+	if options.Issuer == "" || options.Audience == "" {
+		panic("middlewares: CheckOIDCToken requires options.Issuer and options.Audience")
+	}
+	extractors := options.Extractors
+	if len(extractors) == 0 {
+		extractors = []TokenExtractor{FromAuthorizationHeader()}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenString, ok := extractToken(r, extractors)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			claims, err := options.parseWithKeyFunc(tokenString, keys.Keyfunc)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), "user", claims)))
+		})
+	}
+}
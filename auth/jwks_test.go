@@ -0,0 +1,308 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+type testJWKSServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	keys     []map[string]string
+	requests int32
+}
+
+func newTestJWKSServer(t *testing.T) *testJWKSServer {
+	server := &testJWKSServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&server.requests, 1)
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": server.keys})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (server *testJWKSServer) publish(keys ...map[string]string) {
+	server.mu.Lock()
+	server.keys = keys
+	server.mu.Unlock()
+}
+
+func encodeInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func rsaJWK(t *testing.T, kid string) (*rsa.PrivateKey, map[string]string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, map[string]string{
+		"kty": "RSA", "kid": kid, "alg": "RS256", "use": "sig",
+		"n": encodeInt(key.N), "e": encodeInt(big.NewInt(int64(key.E))),
+	}
+}
+
+func ecJWK(t *testing.T, kid string) (*ecdsa.PrivateKey, map[string]string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, map[string]string{
+		"kty": "EC", "kid": kid, "crv": "P-256",
+		"x": encodeInt(key.X), "y": encodeInt(key.Y),
+	}
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":   "https://issuer.example",
+		"aud":   []string{"client", "other"},
+		"sub":   "248289761001",
+		"email": "jane@example.com",
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+}
+
+func oidcRequest(t *testing.T, keys *JWKS, options Options, token string) (map[string]interface{}, jwt.Claims) {
+	var user map[string]interface{}
+	var claims jwt.Claims
+	handler := CheckOIDCToken(keys, options)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = GetUser(r)
+		claims = GetClaims(r)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	return user, claims
+}
+
+func TestCheckOIDCToken(t *testing.T) {
+	server := newTestJWKSServer(t)
+	rsaKey, rsaPublic := rsaJWK(t, "rsa")
+	ecKey, ecPublic := ecJWK(t, "ec")
+	server.publish(rsaPublic, ecPublic)
+
+	keys := NewJWKS(server.URL)
+	options := Options{Issuer: "https://issuer.example", Audience: "client"}
+
+	user, _ := oidcRequest(t, keys, options, signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims()))
+	if user == nil || user["email"] != "jane@example.com" {
+		t.Fatalf("RS256 token rejected: %v", user)
+	}
+	user, _ = oidcRequest(t, keys, options, signToken(t, jwt.SigningMethodES256, "ec", ecKey, validClaims()))
+	if user == nil || user["sub"] != "248289761001" {
+		t.Fatalf("ES256 token rejected: %v", user)
+	}
+
+	cases := map[string]func(jwt.MapClaims){
+		"issuer":   func(c jwt.MapClaims) { c["iss"] = "https://evil.example" },
+		"audience": func(c jwt.MapClaims) { c["aud"] = "someone-else" },
+		"expired":  func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() },
+	}
+	for name, mutate := range cases {
+		claims := validClaims()
+		mutate(claims)
+		if user, _ := oidcRequest(t, keys, options, signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims)); user != nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+
+	// a token signed with HS256 using the public modulus as secret must not
+	// pass for the RSA key
+	forged := signToken(t, jwt.SigningMethodHS256, "rsa", []byte(rsaPublic["n"]), validClaims())
+	if user, _ := oidcRequest(t, keys, options, forged); user != nil {
+		t.Error("HS256 token accepted for an RSA key")
+	}
+	if atomic.LoadInt32(&server.requests) != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", atomic.LoadInt32(&server.requests))
+	}
+}
+
+func TestCheckOIDCTokenRequiresIssuerAndAudience(t *testing.T) {
+	keys := NewJWKS("https://issuer.example/jwks")
+	for name, options := range map[string]Options{
+		"issuer":   {Audience: "client"},
+		"audience": {Issuer: "https://issuer.example"},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("missing %s accepted", name)
+				}
+			}()
+			CheckOIDCToken(keys, options)
+		}()
+	}
+}
+
+func TestCheckOIDCTokenClaimsType(t *testing.T) {
+	server := newTestJWKSServer(t)
+	key, public := rsaJWK(t, "rsa")
+	server.publish(public)
+
+	options := Options{
+		Issuer:    "https://issuer.example",
+		Audience:  "other",
+		NewClaims: func() jwt.Claims { return &OIDCClaims{} },
+	}
+	_, claims := oidcRequest(t, NewJWKS(server.URL), options, signToken(t, jwt.SigningMethodRS256, "rsa", key, validClaims()))
+	oidc, ok := claims.(*OIDCClaims)
+	if !ok {
+		t.Fatalf("claims are %T", claims)
+	}
+	if oidc.Email != "jane@example.com" || len(oidc.Audience) != 2 {
+		t.Errorf("unexpected claims %+v", oidc)
+	}
+}
+
+func TestJWKSRefreshOnUnknownKid(t *testing.T) {
+	server := newTestJWKSServer(t)
+	oldKey, oldPublic := rsaJWK(t, "old")
+	newKey, newPublic := rsaJWK(t, "new")
+	server.publish(oldPublic)
+	token := signToken(t, jwt.SigningMethodRS256, "new", newKey, validClaims())
+
+	now := time.Now()
+	keys := &JWKS{URL: server.URL, Now: func() time.Time { return now }}
+	options := Options{Issuer: "https://issuer.example", Audience: "client"}
+	if user, _ := oidcRequest(t, keys, options, signToken(t, jwt.SigningMethodRS256, "old", oldKey, validClaims())); user == nil {
+		t.Fatal("token rejected")
+	}
+
+	// the provider rotates its key, the first token with the new kid comes
+	// within the refresh interval and is rejected without a fetch
+	server.publish(newPublic)
+	if user, _ := oidcRequest(t, keys, options, token); user != nil {
+		t.Fatal("token accepted before refresh")
+	}
+	if atomic.LoadInt32(&server.requests) != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", atomic.LoadInt32(&server.requests))
+	}
+
+	now = now.Add(defaultJWKSRefreshInterval)
+	if user, _ := oidcRequest(t, keys, options, token); user == nil {
+		t.Fatal("token rejected after refresh")
+	}
+
+	// unknown kids are rate limited too
+	for i := 0; i < 5; i++ {
+		oidcRequest(t, keys, options, signToken(t, jwt.SigningMethodRS256, "bogus", newKey, validClaims()))
+	}
+	if atomic.LoadInt32(&server.requests) != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", atomic.LoadInt32(&server.requests))
+	}
+}
+
+func TestJWKSFromFile(t *testing.T) {
+	key, public := rsaJWK(t, "local")
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jwks.json")
+	content, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{public}})
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	user, _ := oidcRequest(t, NewJWKSFromFile(path), Options{Issuer: "https://issuer.example", Audience: "client"}, signToken(t, jwt.SigningMethodRS256, "local", key, validClaims()))
+	if user == nil {
+		t.Fatal("token rejected")
+	}
+}
+
+func TestJWKSSlowProviderDoesNotBlockCachedKeys(t *testing.T) {
+	key, public := rsaJWK(t, "cached")
+	release := make(chan struct{})
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first fetch answers, the next ones hang until released
+		if atomic.AddInt32(&requests, 1) > 1 {
+			<-release
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{public}})
+	}))
+	defer server.Close()
+	defer close(release)
+
+	now := time.Now()
+	var clock sync.Mutex
+	keys := &JWKS{URL: server.URL, Now: func() time.Time {
+		clock.Lock()
+		defer clock.Unlock()
+		return now
+	}}
+	options := Options{Issuer: "https://issuer.example", Audience: "client"}
+	token := signToken(t, jwt.SigningMethodRS256, "cached", key, validClaims())
+	if user, _ := oidcRequest(t, keys, options, token); user == nil {
+		t.Fatal("token rejected")
+	}
+
+	// an unknown kid starts a fetch that hangs
+	clock.Lock()
+	now = now.Add(defaultJWKSRefreshInterval)
+	clock.Unlock()
+	unknown := make(chan struct{})
+	go func() {
+		oidcRequest(t, keys, options, signToken(t, jwt.SigningMethodRS256, "unknown", key, validClaims()))
+		close(unknown)
+	}()
+	for atomic.LoadInt32(&requests) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	verified := make(chan bool)
+	go func() {
+		user, _ := oidcRequest(t, keys, options, token)
+		verified <- user != nil
+	}()
+	select {
+	case ok := <-verified:
+		if !ok {
+			t.Error("cached key rejected during a refresh")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cached key lookup blocked by the fetch in flight")
+	}
+
+	select {
+	case <-unknown:
+		t.Error("unknown kid returned before the fetch ended")
+	default:
+	}
+	release <- struct{}{}
+	<-unknown
+}
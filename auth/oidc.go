@@ -0,0 +1,362 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/jeffguorg/middlewares/problem"
+)
+
+const (
+	oidcStateCookie  = "oidc_state"
+	oidcLogoutCookie = "oidc_logout"
+	oidcStateQuery   = "state"
+)
+
+var (
+	ErrAuthorizationDenied = errors.New("authorization was denied by the provider")
+	ErrTokenExchange       = errors.New("authorization code exchange failed")
+	ErrNonceMismatch       = errors.New("ID token nonce does not match")
+	ErrLogoutMethod        = errors.New("logout requires POST")
+)
+
+// OIDCClient runs the OpenID Connect authorization code flow with PKCE for a
+// confidential or public client. The handlers rely on CheckUser being
+// installed in front of them, since the callback stores the user with
+// SetUser and logout revokes it with RevokeUser.
+//
+// The state parameter is bound to the browser with SetSecretCookie and
+// checked by SecureCookie. The nonce and PKCE code verifier are derived from
+// the state with CookieKey, so no other value has to be kept between the
+// login and the callback. Logout is protected the same way, with the state
+// returned by LogoutState.
+type OIDCClient struct {
+	// Provider endpoints, filled by DiscoverOIDC
+	Issuer                string
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	EndSessionEndpoint    string
+	Keys                  *JWKS
+
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+
+	// Scopes requested at login, "openid profile email" when empty
+	Scopes []string
+
+	// CookieKey signs the state cookie and derives the nonce and code
+	// verifier
+	CookieKey []byte
+
+	// PostLogoutRedirectURL is where the provider sends users back after
+	// logout, and where LogoutHandler redirects without an end-session
+	// endpoint. "/" when empty.
+	PostLogoutRedirectURL string
+
+	// UserClaims selects the ID token claims stored with SetUser. The
+	// subject and profile claims are kept when nil.
+	UserClaims func(idToken map[string]interface{}) map[string]interface{}
+
+	// ClockSkew is tolerated on the ID token time claims
+	ClockSkew time.Duration
+
+	// HTTPClient performs the token requests, a client with a 10 seconds
+	// timeout when nil
+	HTTPClient *http.Client
+}
+
+// DiscoverOIDC reads the provider metadata of issuer from
+// /.well-known/openid-configuration. The client settings are left to the
+// caller.
+func DiscoverOIDC(issuer string, httpClient *http.Client) (*OIDCClient, error) {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultJWKSTimeout}
+	}
+	resp, err := httpClient.Get(strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovering %s: %s", issuer, resp.Status)
+	}
+
+	var metadata struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		EndSessionEndpoint    string `json:"end_session_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&metadata); err != nil {
+		return nil, err
+	}
+	if metadata.Issuer != issuer {
+		return nil, fmt.Errorf("discovering %s: metadata is for issuer %s", issuer, metadata.Issuer)
+	}
+
+	return &OIDCClient{
+		Issuer:                metadata.Issuer,
+		AuthorizationEndpoint: metadata.AuthorizationEndpoint,
+		TokenEndpoint:         metadata.TokenEndpoint,
+		EndSessionEndpoint:    metadata.EndSessionEndpoint,
+		Keys:                  &JWKS{URL: metadata.JWKSURI, Client: httpClient},
+		HTTPClient:            httpClient,
+	}, nil
+}
+
+// derive returns a secret value bound to state for purpose
+func (client *OIDCClient) derive(purpose, state string) string {
+	hasher := hmac.New(sha256.New, client.CookieKey)
+	hasher.Write([]byte(purpose + ":" + state))
+	return base64.RawURLEncoding.EncodeToString(hasher.Sum(nil))
+}
+
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// localRedirect keeps only same-site paths, so login cannot be used as an
+// open redirect
+func localRedirect(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+	return target
+}
+
+// LoginHandler redirects to the provider. The path in the next query
+// parameter is where the callback sends the user once logged in.
+func (client *OIDCClient) LoginHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		random := make([]byte, 24)
+		if _, err := rand.Read(random); err != nil {
+			problem.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		// the return path travels in the state so it is covered by the
+		// state cookie
+		next := localRedirect(r.URL.Query().Get("next"))
+		state := base64.RawURLEncoding.EncodeToString(random) + "." + base64.RawURLEncoding.EncodeToString([]byte(next))
+		SetSecretCookie(w, oidcStateCookie, []byte(state), client.CookieKey)
+
+		scopes := client.Scopes
+		if len(scopes) == 0 {
+			scopes = []string{"openid", "profile", "email"}
+		}
+		query := url.Values{
+			"response_type":         {"code"},
+			"client_id":             {client.ClientID},
+			"redirect_uri":          {client.RedirectURL},
+			"scope":                 {strings.Join(scopes, " ")},
+			"state":                 {state},
+			"nonce":                 {client.derive("nonce", state)},
+			"code_challenge":        {codeChallenge(client.derive("pkce", state))},
+			"code_challenge_method": {"S256"},
+		}
+		target := client.AuthorizationEndpoint
+		if strings.Contains(target, "?") {
+			target += "&" + query.Encode()
+		} else {
+			target += "?" + query.Encode()
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+	})
+}
+
+// CallbackHandler handles the redirect from the provider: it checks the
+// state, exchanges the code, validates the ID token and its nonce, stores
+// the user with SetUser and redirects to the path given at login. It responds
+// with 500 when the user cannot be stored, e.g. because CheckUser is not
+// installed in front of it.
+func (client *OIDCClient) CallbackHandler() http.Handler {
+	callback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		state := query.Get(oidcStateQuery)
+		http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/", MaxAge: -1})
+
+		if providerError := query.Get("error"); providerError != "" {
+			problem.Respond(w, r, http.StatusUnauthorized, fmt.Errorf("%w: %s %s", ErrAuthorizationDenied, providerError, query.Get("error_description")))
+			return
+		}
+
+		idToken, err := client.exchange(query.Get("code"), client.derive("pkce", state))
+		if err != nil {
+			problem.Respond(w, r, http.StatusBadGateway, err)
+			return
+		}
+
+		options := Options{
+			Issuer:         client.Issuer,
+			Audience:       client.ClientID,
+			ClockSkew:      client.ClockSkew,
+			RequiredClaims: []string{"sub", "exp", "iat"},
+		}
+		claims, err := options.parseWithKeyFunc(idToken, client.Keys.Keyfunc)
+		if err != nil {
+			problem.Respond(w, r, http.StatusUnauthorized, err)
+			return
+		}
+		idClaims, err := claimsMap(claims)
+		if err != nil {
+			problem.Respond(w, r, http.StatusUnauthorized, err)
+			return
+		}
+		if nonce, _ := idClaims["nonce"].(string); !hmac.Equal([]byte(nonce), []byte(client.derive("nonce", state))) {
+			problem.Respond(w, r, http.StatusUnauthorized, ErrNonceMismatch)
+			return
+		}
+
+		userClaims := client.UserClaims
+		if userClaims == nil {
+			userClaims = profileClaims
+		}
+		if err := issueUser(w, r, userClaims(idClaims)); err != nil {
+			problem.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		next := "/"
+		if i := strings.IndexByte(state, '.'); i >= 0 {
+			if decoded, err := base64.RawURLEncoding.DecodeString(state[i+1:]); err == nil {
+				next = localRedirect(string(decoded))
+			}
+		}
+		http.Redirect(w, r, next, http.StatusFound)
+	})
+	return SecureCookie(client.CookieKey, oidcStateQuery, oidcStateCookie)(callback)
+}
+
+// profileClaims keeps the subject and the standard profile claims
+func profileClaims(idToken map[string]interface{}) map[string]interface{} {
+	user := make(map[string]interface{})
+	for _, name := range []string{"sub", "name", "given_name", "family_name", "preferred_username", "email", "email_verified", "picture", "locale"} {
+		if value, ok := idToken[name]; ok {
+			user[name] = value
+		}
+	}
+	return user
+}
+
+// exchange trades the authorization code for tokens and returns the ID token
+func (client *OIDCClient) exchange(code, verifier string) (string, error) {
+	if code == "" {
+		return "", fmt.Errorf("%w: code is missing", ErrTokenExchange)
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {client.RedirectURL},
+		"client_id":     {client.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest(http.MethodPost, client.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if client.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(client.ClientID), url.QueryEscape(client.ClientSecret))
+	}
+
+	httpClient := client.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultJWKSTimeout}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrTokenExchange, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrTokenExchange, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: %s", ErrTokenExchange, resp.Status)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrTokenExchange, err)
+	}
+	if tokens.IDToken == "" {
+		return "", fmt.Errorf("%w: no id_token in response", ErrTokenExchange)
+	}
+	return tokens.IDToken, nil
+}
+
+// LogoutState binds a new logout state to the browser and returns it. Pages
+// offering logout send it as the state field of a POST form to
+// LogoutHandler; only the latest state is accepted.
+func (client *OIDCClient) LogoutState(w http.ResponseWriter) (string, error) {
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	state := base64.RawURLEncoding.EncodeToString(random)
+	SetSecretCookie(w, oidcLogoutCookie, []byte(state), client.CookieKey)
+	return state, nil
+}
+
+// LogoutHandler removes the user cookie, revokes the refresh token and, when
+// the provider has an end-session endpoint, logs the user out there too.
+// Only POST requests carrying the state from LogoutState are accepted, so
+// other sites cannot log users out.
+func (client *OIDCClient) LogoutHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			problem.Respond(w, r, http.StatusMethodNotAllowed, ErrLogoutMethod)
+			return
+		}
+		state := r.PostFormValue(oidcStateQuery)
+		cookie, err := r.Cookie(oidcLogoutCookie)
+		if err != nil || state == "" || CheckSecureCookie(cookie.Value, []byte(state), client.CookieKey) != nil {
+			problem.Respond(w, r, http.StatusBadRequest, ErrCSRF)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: oidcLogoutCookie, Path: "/", MaxAge: -1})
+
+		if err := RevokeUser(w, r); err != nil {
+			problem.Respond(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		postLogout := client.PostLogoutRedirectURL
+		if postLogout == "" {
+			postLogout = "/"
+		}
+		if client.EndSessionEndpoint == "" {
+			http.Redirect(w, r, postLogout, http.StatusFound)
+			return
+		}
+
+		query := url.Values{"client_id": {client.ClientID}}
+		if client.PostLogoutRedirectURL != "" {
+			query.Set("post_logout_redirect_uri", client.PostLogoutRedirectURL)
+		}
+		target := client.EndSessionEndpoint
+		if strings.Contains(target, "?") {
+			target += "&" + query.Encode()
+		} else {
+			target += "?" + query.Encode()
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+	})
+}
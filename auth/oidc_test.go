@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// testProvider is an OpenID Connect provider issuing ID tokens for the codes
+// it was told about
+type testProvider struct {
+	*httptest.Server
+	key   *rsa.PrivateKey
+	mu    sync.Mutex
+	codes map[string]jwt.MapClaims // claims of the ID token by code
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	key, jwk := rsaJWK(t, "k1")
+	provider := &testProvider{key: key, codes: make(map[string]jwt.MapClaims)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 provider.URL,
+			"authorization_endpoint": provider.URL + "/authorize",
+			"token_endpoint":         provider.URL + "/token",
+			"end_session_endpoint":   provider.URL + "/logout",
+			"jwks_uri":               provider.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{jwk}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		provider.mu.Lock()
+		claims, ok := provider.codes[r.PostFormValue("code")]
+		provider.mu.Unlock()
+		if !ok || codeChallenge(r.PostFormValue("code_verifier")) != claims["code_challenge"] {
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+			return
+		}
+		delete(claims, "code_challenge")
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": signToken(t, jwt.SigningMethodRS256, "k1", key, claims)})
+	})
+	provider.Server = httptest.NewServer(mux)
+	t.Cleanup(provider.Close)
+	return provider
+}
+
+// authorize issues a code for the login redirect, with overrides applied to
+// the ID token claims
+func (provider *testProvider) authorize(t *testing.T, login *url.URL, clientID, code string, overrides jwt.MapClaims) {
+	query := login.Query()
+	claims := jwt.MapClaims{
+		"iss":            provider.URL,
+		"aud":            clientID,
+		"sub":            "jane",
+		"email":          "jane@example.com",
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"nonce":          query.Get("nonce"),
+		"code_challenge": query.Get("code_challenge"),
+	}
+	for k, v := range overrides {
+		claims[k] = v
+	}
+	provider.mu.Lock()
+	provider.codes[code] = claims
+	provider.mu.Unlock()
+}
+
+type oidcApp struct {
+	client  *OIDCClient
+	handler http.Handler
+	user    map[string]interface{}
+}
+
+func newOIDCApp(t *testing.T, provider *testProvider, checkUser bool) *oidcApp {
+	client, err := DiscoverOIDC(provider.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.ClientID = "app"
+	client.RedirectURL = "https://app.example/callback"
+	client.CookieKey = []byte("cookie key")
+
+	app := &oidcApp{client: client}
+	mux := http.NewServeMux()
+	mux.Handle("/login", client.LoginHandler())
+	mux.Handle("/callback", client.CallbackHandler())
+	mux.Handle("/logout", client.LogoutHandler())
+	mux.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		app.user = GetUser(r)
+	})
+	app.handler = mux
+	if checkUser {
+		app.handler = CheckUser([]byte("user key"), jwt.SigningMethodHS256, Options{})(mux)
+	}
+	return app
+}
+
+func (app *oidcApp) do(r *http.Request, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	app.handler.ServeHTTP(w, r)
+	return w
+}
+
+func responseCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// login runs the login redirect and returns the provider URL and the state
+// cookie
+func (app *oidcApp) login(t *testing.T) (*url.URL, *http.Cookie) {
+	w := app.do(httptest.NewRequest(http.MethodGet, "/login?next=/dashboard", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login status %d", w.Code)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location, responseCookie(w, oidcStateCookie)
+}
+
+func callbackRequest(location *url.URL, code string) *http.Request {
+	return httptest.NewRequest(http.MethodGet, "/callback?code="+code+"&state="+url.QueryEscape(location.Query().Get("state")), nil)
+}
+
+func TestOIDCLoginFlow(t *testing.T) {
+	provider := newTestProvider(t)
+	app := newOIDCApp(t, provider, true)
+
+	location, stateCookie := app.login(t)
+	if !strings.HasPrefix(location.String(), provider.URL+"/authorize?") || location.Query().Get("code_challenge_method") != "S256" {
+		t.Fatalf("login redirects to %s", location)
+	}
+	provider.authorize(t, location, "app", "code-1", nil)
+
+	w := app.do(callbackRequest(location, "code-1"), stateCookie)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/dashboard" {
+		t.Fatalf("callback status %d to %q: %s", w.Code, w.Header().Get("Location"), w.Body)
+	}
+	userCookie := responseCookie(w, "user")
+	if userCookie == nil {
+		t.Fatal("callback set no user cookie")
+	}
+	app.do(httptest.NewRequest(http.MethodGet, "/me", nil), userCookie)
+	if app.user["sub"] != "jane" || app.user["email"] != "jane@example.com" {
+		t.Errorf("user %v", app.user)
+	}
+}
+
+func TestOIDCCallbackRejects(t *testing.T) {
+	provider := newTestProvider(t)
+	app := newOIDCApp(t, provider, true)
+	forgedState := &http.Cookie{Name: oidcStateCookie, Value: digest([]byte("cookie key"), []byte("attacker state"))}
+
+	cases := []struct {
+		name      string
+		overrides jwt.MapClaims
+		noCookie  bool
+		cookie    *http.Cookie // replaces the state cookie when set
+		status    int
+	}{
+		{name: "nonce", overrides: jwt.MapClaims{"nonce": "replayed"}, status: http.StatusUnauthorized},
+		{name: "audience", overrides: jwt.MapClaims{"aud": "other"}, status: http.StatusUnauthorized},
+		{name: "issuer", overrides: jwt.MapClaims{"iss": "https://evil.example"}, status: http.StatusUnauthorized},
+		{name: "expired", overrides: jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}, status: http.StatusUnauthorized},
+		{name: "no state cookie", noCookie: true, status: http.StatusBadRequest},
+		{name: "other state cookie", cookie: forgedState, status: http.StatusBadRequest},
+	}
+	for i, c := range cases {
+		location, stateCookie := app.login(t)
+		code := fmt.Sprintf("code-%d", i)
+		provider.authorize(t, location, "app", code, c.overrides)
+
+		var cookies []*http.Cookie
+		switch {
+		case c.cookie != nil:
+			cookies = append(cookies, c.cookie)
+		case !c.noCookie:
+			cookies = append(cookies, stateCookie)
+		}
+		w := app.do(callbackRequest(location, code), cookies...)
+		if w.Code != c.status {
+			t.Errorf("%s: status %d, want %d", c.name, w.Code, c.status)
+		}
+		if responseCookie(w, "user") != nil {
+			t.Errorf("%s: user cookie set", c.name)
+		}
+	}
+}
+
+func TestOIDCCallbackWithoutCheckUser(t *testing.T) {
+	provider := newTestProvider(t)
+	app := newOIDCApp(t, provider, false)
+
+	location, stateCookie := app.login(t)
+	provider.authorize(t, location, "app", "code-1", nil)
+	if w := app.do(callbackRequest(location, "code-1"), stateCookie); w.Code != http.StatusInternalServerError {
+		t.Errorf("status %d, want 500", w.Code)
+	}
+}
+
+func TestOIDCLogout(t *testing.T) {
+	provider := newTestProvider(t)
+	app := newOIDCApp(t, provider, true)
+	user := &http.Cookie{Name: "user", Value: "token"}
+
+	logoutRequest := func(state string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/logout", strings.NewReader(url.Values{"state": {state}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+	form := httptest.NewRecorder()
+	state, err := app.client.LogoutState(form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logoutCookie := responseCookie(form, oidcLogoutCookie)
+
+	if w := app.do(httptest.NewRequest(http.MethodGet, "/logout", nil), user, logoutCookie); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET logout: status %d", w.Code)
+	}
+	if w := app.do(logoutRequest(state), user); w.Code != http.StatusBadRequest {
+		t.Errorf("logout without state cookie: status %d", w.Code)
+	}
+	if w := app.do(logoutRequest("forged"), user, logoutCookie); w.Code != http.StatusBadRequest {
+		t.Errorf("logout with a forged state: status %d", w.Code)
+	}
+
+	w := app.do(logoutRequest(state), user, logoutCookie)
+	if w.Code != http.StatusFound || !strings.HasPrefix(w.Header().Get("Location"), provider.URL+"/logout?") {
+		t.Fatalf("logout status %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	if cookie := responseCookie(w, "user"); cookie == nil || cookie.MaxAge >= 0 {
+		t.Errorf("user cookie not removed: %v", cookie)
+	}
+}
@@ -0,0 +1,347 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-errors/errors"
+)
+
+const (
+	refreshCookie = "user_refresh"
+
+	defaultRefreshLifetime = 30 * 24 * time.Hour
+	defaultRenewBefore     = 5 * time.Minute
+	defaultReuseGrace      = 10 * time.Second
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token is unknown, expired or revoked")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used, its family is revoked")
+
+	// errRefreshRaced reports a reuse within the grace period, typically
+	// concurrent requests sharing the cookie
+	errRefreshRaced = errors.New("refresh token was just rotated")
+)
+
+// RefreshToken is the server side state of a refresh token. Every token
+// rotated from the same login shares a Family.
+type RefreshToken struct {
+	ID        string
+	Family    string
+	User      map[string]interface{}
+	ExpiresAt time.Time
+
+	// FamilyExpiresAt ends the family whatever the rotations, zero when
+	// RefreshOptions.MaxLifetime is not set
+	FamilyExpiresAt time.Time
+
+	// UsedAt is set when the token is rotated, refresh tokens are single use
+	UsedAt time.Time
+}
+
+// RefreshStore keeps refresh tokens
+type RefreshStore interface {
+	Save(token RefreshToken) error
+
+	// Use marks the token used at now and returns it as it was before, so
+	// a non zero UsedAt reveals a reuse. It must check and mark atomically
+	// and return ErrRefreshTokenInvalid for unknown, expired or revoked
+	// tokens.
+	Use(id string, now time.Time) (RefreshToken, error)
+
+	// RevokeFamily invalidates every token of family
+	RevokeFamily(family string) error
+}
+
+// RefreshOptions enables refresh tokens in Options. SetUser then also issues
+// a refresh token in an HttpOnly cookie, and CheckUser rotates it to reissue
+// the access token when it is missing, expired or about to expire. A refresh
+// token used twice revokes every token of its family, since one of the two
+// users must have stolen it.
+type RefreshOptions struct {
+	Store RefreshStore
+
+	// Lifetime of each refresh token, 30 days when 0. The expiration slides
+	// with every rotation.
+	Lifetime time.Duration
+
+	// MaxLifetime caps the sliding expiration: once that long has passed
+	// since login the user must log in again, however often the token was
+	// rotated. 0 disables the cap.
+	MaxLifetime time.Duration
+
+	// RenewBefore reissues access tokens expiring within it, 5 minutes
+	// when 0
+	RenewBefore time.Duration
+
+	// ReuseGrace tolerates a reuse shortly after rotation, for concurrent
+	// requests sent with the same cookie, 10 seconds when 0. Such requests
+	// go on with their access token if it is still valid.
+	ReuseGrace time.Duration
+
+	// Secure sets the Secure attribute of the refresh cookie
+	Secure bool
+}
+
+func (refresh *RefreshOptions) lifetime() time.Duration {
+	if refresh.Lifetime == 0 {
+		return defaultRefreshLifetime
+	}
+	return refresh.Lifetime
+}
+
+// needsRenewal reports whether the access token is missing, invalid or
+// expiring within RenewBefore
+func (refresh *RefreshOptions) needsRenewal(claims jwt.Claims, err error, now time.Time) bool {
+	if err != nil || claims == nil {
+		return true
+	}
+	m, err := claimsMap(claims)
+	if err != nil {
+		return true
+	}
+	exp, ok, err := numericDate(m, "exp")
+	if err != nil || !ok {
+		return err != nil
+	}
+	renewBefore := refresh.RenewBefore
+	if renewBefore == 0 {
+		renewBefore = defaultRenewBefore
+	}
+	return exp.Sub(now) < renewBefore
+}
+
+func randomTokenID() (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// issue saves a new refresh token of family for user and sets its cookie. An
+// empty family starts a new one, its end is then set from MaxLifetime.
+func (refresh *RefreshOptions) issue(w http.ResponseWriter, user map[string]interface{}, family string, familyExpiresAt, now time.Time) error {
+	id, err := randomTokenID()
+	if err != nil {
+		return err
+	}
+	if family == "" {
+		family = id
+		if refresh.MaxLifetime > 0 {
+			familyExpiresAt = now.Add(refresh.MaxLifetime)
+		}
+	}
+
+	expiresAt := now.Add(refresh.lifetime())
+	if !familyExpiresAt.IsZero() && expiresAt.After(familyExpiresAt) {
+		expiresAt = familyExpiresAt
+	}
+	token := RefreshToken{ID: id, Family: family, User: user, ExpiresAt: expiresAt, FamilyExpiresAt: familyExpiresAt}
+	if err := refresh.Store.Save(token); err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(expiresAt.Sub(now) / time.Second),
+		Secure:   refresh.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// rotate consumes the refresh cookie of r, issues its successor and returns
+// the user it was issued for
+func (refresh *RefreshOptions) rotate(w http.ResponseWriter, r *http.Request, now time.Time) (map[string]interface{}, error) {
+	cookie, err := r.Cookie(refreshCookie)
+	if err != nil || cookie.Value == "" {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	old, err := refresh.Store.Use(cookie.Value, now)
+	if err != nil {
+		clearRefreshCookie(w)
+		return nil, err
+	}
+	if !old.UsedAt.IsZero() {
+		grace := refresh.ReuseGrace
+		if grace == 0 {
+			grace = defaultReuseGrace
+		}
+		if now.Sub(old.UsedAt) <= grace {
+			return nil, errRefreshRaced
+		}
+		if err := refresh.Store.RevokeFamily(old.Family); err != nil {
+			return nil, err
+		}
+		UnsetUser(w)
+		clearRefreshCookie(w)
+		return nil, ErrRefreshTokenReused
+	}
+	if now.After(old.ExpiresAt) {
+		clearRefreshCookie(w)
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	if err := refresh.issue(w, old.User, old.Family, old.FamilyExpiresAt, now); err != nil {
+		return nil, err
+	}
+	return copyUser(old.User), nil
+}
+
+// renew reissues the access token of the user of the refresh cookie of r and
+// returns its claims
+func (refresh *RefreshOptions) renew(w http.ResponseWriter, r *http.Request, key interface{}, method jwt.SigningMethod, options Options) (jwt.Claims, error) {
+	user, err := refresh.rotate(w, r, options.now())
+	if err != nil {
+		return nil, err
+	}
+	tokenString, err := options.issue(user, key, method)
+	if err != nil {
+		return nil, err
+	}
+	setUserCookie(w, tokenString)
+	return options.parse(tokenString, key, method)
+}
+
+// revoke ends the family of the refresh cookie of r
+func (refresh *RefreshOptions) revoke(r *http.Request, now time.Time) error {
+	cookie, err := r.Cookie(refreshCookie)
+	if err != nil || cookie.Value == "" {
+		return nil
+	}
+	token, err := refresh.Store.Use(cookie.Value, now)
+	if err == ErrRefreshTokenInvalid {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return refresh.Store.RevokeFamily(token.Family)
+}
+
+func clearRefreshCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+// copyUser copies user claims without the time claims of the token they came
+// from, so reissued tokens get fresh ones
+func copyUser(user map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(user))
+	for k, v := range user {
+		switch k {
+		case "iat", "exp", "nbf", "jti":
+			continue
+		}
+		copied[k] = v
+	}
+	return copied
+}
+
+// RevokeUser logs the user out: the refresh token family is revoked and both
+// cookies are removed
+func RevokeUser(w http.ResponseWriter, r *http.Request) error {
+	options := getOptions(r)
+	UnsetUser(w)
+	if options.Refresh == nil {
+		return nil
+	}
+	clearRefreshCookie(w)
+	return options.Refresh.revoke(r, options.now())
+}
+
+// MemoryRefreshStore keeps refresh tokens in process memory. Tokens are lost
+// on restart and not shared between instances.
+type MemoryRefreshStore struct {
+	mu       sync.Mutex
+	tokens   map[string]*RefreshToken
+	families map[string][]string
+	purgedAt time.Time
+}
+
+// NewMemoryRefreshStore returns an empty store
+func NewMemoryRefreshStore() *MemoryRefreshStore {
+	return &MemoryRefreshStore{
+		tokens:   make(map[string]*RefreshToken),
+		families: make(map[string][]string),
+	}
+}
+
+// Save implements RefreshStore
+func (store *MemoryRefreshStore) Save(token RefreshToken) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.tokens[token.ID] = &token
+	store.families[token.Family] = append(store.families[token.Family], token.ID)
+	return nil
+}
+
+// purge drops the families whose tokens have all expired. It runs from Use,
+// the only method told the time, so it follows the clock of Options.
+func (store *MemoryRefreshStore) purge(now time.Time) {
+	store.purgedAt = now
+	for family, ids := range store.families {
+		expired := true
+		for _, id := range ids {
+			if token, ok := store.tokens[id]; ok && now.Before(token.ExpiresAt) {
+				expired = false
+				break
+			}
+		}
+		if expired {
+			store.deleteFamily(family)
+		}
+	}
+}
+
+func (store *MemoryRefreshStore) deleteFamily(family string) {
+	for _, id := range store.families[family] {
+		delete(store.tokens, id)
+	}
+	delete(store.families, family)
+}
+
+// Use implements RefreshStore
+func (store *MemoryRefreshStore) Use(id string, now time.Time) (RefreshToken, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if now.Sub(store.purgedAt) > time.Minute {
+		store.purge(now)
+	}
+	token, ok := store.tokens[id]
+	if !ok || now.After(token.ExpiresAt) {
+		return RefreshToken{}, ErrRefreshTokenInvalid
+	}
+	previous := *token
+	if token.UsedAt.IsZero() {
+		token.UsedAt = now
+	}
+	return previous, nil
+}
+
+// RevokeFamily implements RefreshStore
+func (store *MemoryRefreshStore) RevokeFamily(family string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.deleteFamily(family)
+	return nil
+}
+
+var (
+	_ RefreshStore = (*MemoryRefreshStore)(nil)
+)
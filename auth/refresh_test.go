@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+type refreshApp struct {
+	handler http.Handler
+	now     time.Time
+	user    map[string]interface{}
+}
+
+func newRefreshApp(t *testing.T) *refreshApp {
+	return newRefreshAppWith(t, &RefreshOptions{Store: NewMemoryRefreshStore()})
+}
+
+func newRefreshAppWith(t *testing.T, refresh *RefreshOptions) *refreshApp {
+	app := &refreshApp{now: time.Now()}
+	options := Options{
+		Lifetime: 10 * time.Minute,
+		Refresh:  refresh,
+		Now:      func() time.Time { return app.now },
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		SetUser(w, r, map[string]interface{}{"sub": "jane"})
+	})
+	mux.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+		if err := RevokeUser(w, r); err != nil {
+			t.Error(err)
+		}
+	})
+	mux.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		app.user = GetUser(r)
+	})
+	app.handler = CheckUser([]byte("secret"), jwt.SigningMethodHS256, options)(mux)
+	return app
+}
+
+// do requests path with cookies and returns the user seen by the handler and
+// the cookies set by the response, by name
+func (app *refreshApp) do(path string, cookies ...*http.Cookie) (map[string]interface{}, map[string]*http.Cookie) {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	for _, cookie := range cookies {
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+	}
+	app.user = nil
+	w := httptest.NewRecorder()
+	app.handler.ServeHTTP(w, r)
+
+	set := make(map[string]*http.Cookie)
+	for _, cookie := range w.Result().Cookies() {
+		set[cookie.Name] = cookie
+	}
+	return app.user, set
+}
+
+func (app *refreshApp) login(t *testing.T) (user, refresh *http.Cookie) {
+	_, set := app.do("/login")
+	if set["user"] == nil || set[refreshCookie] == nil {
+		t.Fatalf("login set cookies %v", set)
+	}
+	if !set[refreshCookie].HttpOnly {
+		t.Error("refresh cookie is readable by scripts")
+	}
+	return set["user"], set[refreshCookie]
+}
+
+func TestRefreshRotation(t *testing.T) {
+	app := newRefreshApp(t)
+	userCookie, first := app.login(t)
+
+	if user, set := app.do("/me", userCookie, first); user["sub"] != "jane" || set[refreshCookie] != nil {
+		t.Fatalf("fresh token: user %v, cookies %v", user, set)
+	}
+
+	// once the access token expired the refresh token is rotated
+	app.now = app.now.Add(time.Hour)
+	user, set := app.do("/me", userCookie, first)
+	if user["sub"] != "jane" {
+		t.Fatalf("expired access token was not renewed: %v", user)
+	}
+	second := set[refreshCookie]
+	if set["user"] == nil || second == nil || second.Value == first.Value {
+		t.Fatalf("rotation set cookies %v", set)
+	}
+
+	// so is a missing one
+	app.now = app.now.Add(time.Minute)
+	user, set = app.do("/me", second)
+	if user["sub"] != "jane" || set[refreshCookie] == nil {
+		t.Fatalf("missing access token was not renewed: user %v, cookies %v", user, set)
+	}
+	if user, _ := app.do("/me", set[refreshCookie]); user == nil {
+		t.Error("third refresh token rejected")
+	}
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	app := newRefreshApp(t)
+	_, first := app.login(t)
+
+	_, set := app.do("/me", first)
+	second := set[refreshCookie]
+	if second == nil {
+		t.Fatal("refresh token was not rotated")
+	}
+
+	// the stolen first token is replayed after the grace period
+	app.now = app.now.Add(time.Minute)
+	user, set := app.do("/me", first)
+	if user != nil {
+		t.Fatalf("reused refresh token accepted: %v", user)
+	}
+	if cookie := set[refreshCookie]; cookie == nil || cookie.MaxAge >= 0 {
+		t.Errorf("refresh cookie not removed after reuse: %v", cookie)
+	}
+
+	// which revokes the legitimate successor too
+	if user, _ := app.do("/me", second); user != nil {
+		t.Errorf("successor of a reused token accepted: %v", user)
+	}
+
+	// other logins are not affected
+	_, other := app.login(t)
+	if user, _ := app.do("/me", other); user == nil {
+		t.Error("refresh token of another login rejected")
+	}
+}
+
+func TestRefreshReuseWithinGrace(t *testing.T) {
+	app := newRefreshApp(t)
+	_, first := app.login(t)
+
+	_, set := app.do("/me", first)
+	second := set[refreshCookie]
+
+	// a concurrent request sent with the same cookie is not a theft
+	app.now = app.now.Add(time.Second)
+	if user, _ := app.do("/me", first); user != nil {
+		t.Errorf("reused refresh token renewed the access token: %v", user)
+	}
+	if user, _ := app.do("/me", second); user == nil {
+		t.Error("successor rejected after a reuse within the grace period")
+	}
+}
+
+func TestRefreshRejects(t *testing.T) {
+	app := newRefreshApp(t)
+	_, refresh := app.login(t)
+
+	forged := &http.Cookie{Name: refreshCookie, Value: "forged"}
+	if user, _ := app.do("/me", forged); user != nil {
+		t.Errorf("unknown refresh token accepted: %v", user)
+	}
+
+	app.now = app.now.Add(defaultRefreshLifetime + time.Minute)
+	if user, _ := app.do("/me", refresh); user != nil {
+		t.Errorf("expired refresh token accepted: %v", user)
+	}
+}
+
+func TestRevokeUser(t *testing.T) {
+	app := newRefreshApp(t)
+	userCookie, refresh := app.login(t)
+
+	_, set := app.do("/logout", userCookie, refresh)
+	for _, name := range []string{"user", refreshCookie} {
+		if cookie := set[name]; cookie == nil || cookie.MaxAge >= 0 {
+			t.Errorf("%s cookie not removed: %v", name, cookie)
+		}
+	}
+	if user, _ := app.do("/me", refresh); user != nil {
+		t.Errorf("refresh token accepted after logout: %v", user)
+	}
+}
+
+func TestRefreshMaxLifetime(t *testing.T) {
+	app := newRefreshAppWith(t, &RefreshOptions{
+		Store:       NewMemoryRefreshStore(),
+		Lifetime:    24 * time.Hour,
+		MaxLifetime: 36 * time.Hour,
+	})
+	_, refresh := app.login(t)
+	if refresh.MaxAge != int((24 * time.Hour).Seconds()) {
+		t.Errorf("refresh cookie MaxAge %d", refresh.MaxAge)
+	}
+
+	// rotating within the lifetime slides the expiration, up to the cap
+	app.now = app.now.Add(20 * time.Hour)
+	user, set := app.do("/me", refresh)
+	if user == nil || set[refreshCookie] == nil {
+		t.Fatalf("refresh token rejected within its lifetime: %v", set)
+	}
+	refresh = set[refreshCookie]
+	if refresh.MaxAge != int((16 * time.Hour).Seconds()) {
+		t.Errorf("rotated refresh cookie MaxAge %d, want the rest of the family lifetime", refresh.MaxAge)
+	}
+
+	app.now = app.now.Add(16*time.Hour + time.Second)
+	if user, _ := app.do("/me", refresh); user != nil {
+		t.Errorf("refresh token accepted past the family lifetime: %v", user)
+	}
+}
+
+func TestMemoryRefreshStoreClock(t *testing.T) {
+	store := NewMemoryRefreshStore()
+	issued := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, id := range []string{"a", "b"} {
+		if err := store.Save(RefreshToken{ID: id, Family: id, ExpiresAt: issued.Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// tokens long expired by the wall clock are valid by the injected one
+	if _, err := store.Use("a", issued); err != nil {
+		t.Fatalf("token rejected at its issue time: %v", err)
+	}
+	if _, err := store.Use("b", issued.Add(2*time.Hour)); err != ErrRefreshTokenInvalid {
+		t.Errorf("expired token: %v", err)
+	}
+	if len(store.tokens) != 0 || len(store.families) != 0 {
+		t.Errorf("expired families kept: %v", store.families)
+	}
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+)
+
+// TokenExtractor finds the JWT in a request, ok is false when it is absent
+type TokenExtractor func(r *http.Request) (token string, ok bool)
+
+// FromCookie reads the token from the named cookie
+func FromCookie(name string) TokenExtractor {
+	return func(r *http.Request) (string, bool) {
+		cookie, err := r.Cookie(name)
+		if err != nil || cookie.Value == "" {
+			return "", false
+		}
+		return cookie.Value, true
+	}
+}
+
+// FromAuthorizationHeader reads the token from `Authorization: Bearer <jwt>`
+func FromAuthorizationHeader() TokenExtractor {
+	return func(r *http.Request) (string, bool) {
+		parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+		if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+			return "", false
+		}
+		token := strings.TrimSpace(parts[1])
+		return token, token != ""
+	}
+}
+
+// FromHeader reads the token from a custom header
+func FromHeader(name string) TokenExtractor {
+	return func(r *http.Request) (string, bool) {
+		token := r.Header.Get(name)
+		return token, token != ""
+	}
+}
+
+// FromQuery reads the token from a query parameter. Query strings end up in
+// logs and referrers, prefer headers when the client can send them.
+func FromQuery(name string) TokenExtractor {
+	return func(r *http.Request) (string, bool) {
+		token := r.URL.Query().Get(name)
+		return token, token != ""
+	}
+}
+
+// extractToken returns the token of the first extractor that finds one
+func extractToken(r *http.Request, extractors []TokenExtractor) (string, bool) {
+	for _, extract := range extractors {
+		if token, ok := extract(r); ok {
+			return token, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestTokenExtractors(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/?access_token=from-query", nil)
+	r.Header.Set("Authorization", "bearer  from-header ")
+	r.Header.Set("X-Token", "from-custom")
+	r.AddCookie(&http.Cookie{Name: "user", Value: "from-cookie"})
+
+	cases := map[string]struct {
+		extractor TokenExtractor
+		token     string
+	}{
+		"cookie":        {FromCookie("user"), "from-cookie"},
+		"authorization": {FromAuthorizationHeader(), "from-header"},
+		"header":        {FromHeader("X-Token"), "from-custom"},
+		"query":         {FromQuery("access_token"), "from-query"},
+	}
+	for name, c := range cases {
+		if token, ok := c.extractor(r); !ok || token != c.token {
+			t.Errorf("%s: %q %v, want %q", name, token, ok, c.token)
+		}
+	}
+
+	empty := httptest.NewRequest(http.MethodGet, "/", nil)
+	empty.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+	for name, c := range cases {
+		if token, ok := c.extractor(empty); ok {
+			t.Errorf("%s found %q in a request without token", name, token)
+		}
+	}
+	empty.Header.Set("Authorization", "Bearer ")
+	if token, ok := FromAuthorizationHeader()(empty); ok {
+		t.Errorf("empty bearer token found: %q", token)
+	}
+
+	// the first extractor finding a token wins
+	token, _ := extractToken(r, []TokenExtractor{FromHeader("X-Missing"), FromQuery("access_token"), FromCookie("user")})
+	if token != "from-query" {
+		t.Errorf("chain found %q", token)
+	}
+}
+
+func TestCheckUserTokenBearer(t *testing.T) {
+	key := []byte("secret")
+	middleware := CheckUserToken(key, jwt.SigningMethodHS256, FromCookie("user"), FromAuthorizationHeader())
+	var user map[string]interface{}
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = GetUser(r)
+	}))
+	bearer := func(token string) map[string]interface{} {
+		user = nil
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		return user
+	}
+	claims := jwt.MapClaims{"sub": "jane", "exp": time.Now().Add(time.Hour).Unix()}
+
+	if user := bearer(signToken(t, jwt.SigningMethodHS256, "", key, claims)); user["sub"] != "jane" {
+		t.Fatalf("bearer token rejected: %v", user)
+	}
+	if user := bearer(signToken(t, jwt.SigningMethodHS256, "", []byte("guess"), claims)); user != nil {
+		t.Errorf("token signed with another key accepted: %v", user)
+	}
+	if user := bearer(signToken(t, jwt.SigningMethodHS512, "", key, claims)); user != nil {
+		t.Errorf("token signed with another algorithm accepted: %v", user)
+	}
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user := bearer(unsigned); user != nil {
+		t.Errorf("unsigned token accepted: %v", user)
+	}
+	expired := jwt.MapClaims{"sub": "jane", "exp": time.Now().Add(-time.Hour).Unix()}
+	if user := bearer(signToken(t, jwt.SigningMethodHS256, "", key, expired)); user != nil {
+		t.Errorf("expired token accepted: %v", user)
+	}
+}
@@ -0,0 +1,338 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/jeffguorg/middlewares/problem"
+)
+
+const (
+	boundCtxKey = "IsylLzqZ.bound"
+)
+
+var (
+	fileHeaderType        = reflect.TypeOf((*multipart.FileHeader)(nil))
+	fileHeaderSliceType   = reflect.TypeOf([]*multipart.FileHeader(nil))
+	uploadedFileType      = reflect.TypeOf(UploadedFile{})
+	uploadedFileSliceType = reflect.TypeOf([]UploadedFile(nil))
+)
+
+// BindError describes a field that could not be decoded from the request
+type BindError struct {
+	Field  string
+	Source Source
+	Err    error
+}
+
+func (e *BindError) Error() string {
+	return fmt.Sprintf("cannot bind %s parameter %q: %v", e.Source, e.Field, e.Err)
+}
+
+func (e *BindError) Unwrap() error {
+	return e.Err
+}
+
+// InvalidParams implements problem.InvalidParamsError
+func (e *BindError) InvalidParams() []problem.InvalidParam {
+	return []problem.InvalidParam{{Name: e.Field, Reason: e.Err.Error()}}
+}
+
+// Bind decodes the request into a fresh copy of the struct v points to and
+// stores it in context. Fields are filled from the body using `json` tags,
+// whatever codec is registered for its Content-Type (unknown ones are
+// rejected with 415), then from the query string, form and chi URL params
+// using `query`, `form` and `path` tags. Slice fields accept repeated keys, bracketed keys
+// (ids[]=1&ids[]=2) and comma separated values. time.Time fields accept a `layout` tag, RFC3339 is
+// used otherwise. Fields may declare rules in a `validate` tag using the
+// same syntax as the RequireParameters* keys, e.g. `validate:"required,min=3"`;
+// nested structs are checked too and reported as "parent.child".
+//
+// Files bind to *multipart.FileHeader fields, or to UploadedFile fields
+// when Upload received the body.
+//
+// Path values are only available once chi has routed the request, so Bind
+// should be mounted with router.With or inside a route rather than router.Use.
+func Bind(v interface{}) func(http.Handler) http.Handler {
+	typ := reflect.TypeOf(v)
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		panic("middlewares: Bind requires a struct or a pointer to struct")
+	}
+	rules := compileStructRules(typ, nil, "")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			target := reflect.New(typ)
+			filled := make(presence)
+
+			if !isFormRequest(r) {
+				values, mediaType, status, err := decodeBody(r, "")
+				if err != nil {
+					problem.Respond(w, r, status, err)
+					return
+				}
+				if err := bindBody(values, target.Elem(), bodySource(mediaType), "", nil, filled); err != nil {
+					problem.Respond(w, r, http.StatusBadRequest, err)
+					return
+				}
+			}
+
+			if err := bindValues(r, target.Elem(), nil, filled); err != nil {
+				problem.Respond(w, r, http.StatusBadRequest, err)
+				return
+			}
+
+			if err := validateStruct(target.Elem(), rules, filled); err != nil {
+				problem.Respond(w, r, http.StatusBadRequest, err)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), boundCtxKey, target.Interface())
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Bound returns the struct pointer stored by Bind, or nil if Bind did not run.
+// Callers assert it back to the type given to Bind:
+//
+//	params := middlewares.Bound(r).(*SearchParams)
+func Bound(r *http.Request) interface{} {
+	return r.Context().Value(boundCtxKey)
+}
+
+// presence records the fields Bind filled from the request, by index, so
+// rules can tell a zero value that was sent from a missing one
+type presence map[string]bool
+
+func presenceKey(index []int) string {
+	return fmt.Sprint(index)
+}
+
+func (p presence) mark(index []int) {
+	p[presenceKey(index)] = true
+}
+
+func (p presence) has(index []int) bool {
+	return p[presenceKey(index)]
+}
+
+func childIndex(index []int, i int) []int {
+	return append(append([]int(nil), index...), i)
+}
+
+func isFormRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data"
+}
+
+// bindBody fills the fields of target from the decoded body members named by
+// their `json` tags. Members that don't decode into their field as is, such
+// as the strings of XML bodies, are converted like query parameters.
+func bindBody(values map[string]interface{}, target reflect.Value, source Source, prefix string, index []int, filled presence) error {
+	typ := target.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		value := target.Field(i)
+		fieldIndex := childIndex(index, i)
+		if field.PkgPath != "" || field.Tag.Get("json") == "-" {
+			continue
+		}
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if err := bindBody(values, value, source, prefix, fieldIndex, filled); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := tagName(field, "json")
+		if name == "" {
+			name = field.Name
+		}
+		member, ok := lookupMember(values, name)
+		if !ok || member == nil {
+			continue
+		}
+		filled.mark(fieldIndex)
+
+		if nested, ok := member.(map[string]interface{}); ok && field.Type.Kind() == reflect.Struct && field.Type != timeType {
+			if err := bindBody(nested, value, source, prefix+name+".", fieldIndex, filled); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := setMember(value, member, field.Tag.Get("layout")); err != nil {
+			return &BindError{Field: prefix + name, Source: source, Err: err}
+		}
+	}
+	return nil
+}
+
+// lookupMember finds name in values, falling back to a case insensitive
+// match like encoding/json
+func lookupMember(values map[string]interface{}, name string) (interface{}, bool) {
+	if member, ok := values[name]; ok {
+		return member, true
+	}
+	for key, member := range values {
+		if strings.EqualFold(key, name) {
+			return member, true
+		}
+	}
+	return nil, false
+}
+
+func setMember(field reflect.Value, member interface{}, layout string) error {
+	content, err := json.Marshal(member)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(content, field.Addr().Interface())
+	if err == nil {
+		return nil
+	}
+
+	var texts []string
+	switch v := member.(type) {
+	case string:
+		texts = []string{v}
+	case []string:
+		texts = v
+	case []interface{}:
+		for _, item := range v {
+			text, ok := item.(string)
+			if !ok {
+				return err
+			}
+			texts = append(texts, text)
+		}
+	default:
+		return err
+	}
+	field.Set(reflect.Zero(field.Type()))
+	return setField(field, texts, layout)
+}
+
+func bindValues(r *http.Request, target reflect.Value, index []int, filled presence) error {
+	typ := target.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		value := target.Field(i)
+		fieldIndex := childIndex(index, i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if err := bindValues(r, value, fieldIndex, filled); err != nil {
+				return err
+			}
+			continue
+		}
+
+		layout := field.Tag.Get("layout")
+
+		if name := tagName(field, "query"); name != "" {
+			query := r.URL.Query()
+			values := append(query[name+"[]"], query[name]...)
+			if err := setField(value, values, layout); err != nil {
+				return &BindError{Field: name, Source: SourceQuery, Err: err}
+			}
+			if len(values) > 0 {
+				filled.mark(fieldIndex)
+			}
+		}
+
+		if name := tagName(field, "form"); name != "" && isFormRequest(r) {
+			ok, err := bindFormField(r, name, value, layout)
+			if err != nil {
+				return &BindError{Field: name, Source: SourceForm, Err: err}
+			}
+			if ok {
+				filled.mark(fieldIndex)
+			}
+		}
+
+		if name := tagName(field, "path"); name != "" {
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				if param := rctx.URLParam(name); param != "" {
+					if err := setField(value, []string{param}, layout); err != nil {
+						return &BindError{Field: name, Source: SourcePath, Err: err}
+					}
+					filled.mark(fieldIndex)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// bindFormField sets value from the form and reports whether name was sent
+func bindFormField(r *http.Request, name string, value reflect.Value, layout string) (bool, error) {
+	uploads, uploaded := getUploads(r)
+	switch value.Type() {
+	case uploadedFileType, uploadedFileSliceType:
+		files := uploads[name]
+		if len(files) == 0 {
+			return false, nil
+		}
+		if value.Type() == uploadedFileType {
+			value.Set(reflect.ValueOf(files[0]))
+		} else {
+			value.Set(reflect.ValueOf(files))
+		}
+		return true, nil
+	case fileHeaderType, fileHeaderSliceType:
+		if uploaded {
+			if len(uploads[name]) > 0 {
+				return false, ErrFileStreamed
+			}
+			return false, nil
+		}
+		if err := r.ParseMultipartForm(defaultMaxMemory); err != nil {
+			return false, err
+		}
+		headers := r.MultipartForm.File[name]
+		if len(headers) == 0 {
+			return false, nil
+		}
+		if value.Type() == fileHeaderType {
+			value.Set(reflect.ValueOf(headers[0]))
+		} else {
+			value.Set(reflect.ValueOf(headers))
+		}
+		return true, nil
+	}
+
+	if err := r.ParseMultipartForm(defaultMaxMemory); err != nil && err != http.ErrNotMultipart {
+		return false, err
+	}
+	if values, ok := r.PostForm[name]; ok {
+		return true, setField(value, values, layout)
+	}
+	return false, nil
+}
+
+func tagName(field reflect.StructField, key string) string {
+	tag := field.Tag.Get(key)
+	if tag == "-" {
+		return ""
+	}
+	if idx := strings.IndexByte(tag, ','); idx >= 0 {
+		tag = tag[:idx]
+	}
+	return tag
+}
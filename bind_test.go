@@ -0,0 +1,168 @@
+package middlewares
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jeffguorg/middlewares/problem"
+)
+
+// upper is a TextUnmarshaler that upper-cases its text
+type upper string
+
+func (u *upper) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		return errors.New("empty")
+	}
+	*u = upper(strings.ToUpper(string(text)))
+	return nil
+}
+
+type bindTestParams struct {
+	Since   time.Time     `query:"since" layout:"2006-01-02"`
+	At      time.Time     `query:"at"`
+	Timeout time.Duration `query:"timeout"`
+	Ratio   float64       `query:"ratio"`
+	Debug   bool          `query:"debug"`
+	IDs     []int         `query:"ids"`
+	Code    upper         `query:"code"`
+	IP      net.IP        `query:"ip"`
+	Limit   *int          `query:"limit"`
+}
+
+func TestBindQueryConversions(t *testing.T) {
+	query := "since=2020-06-01&at=2020-06-01T12:00:00Z&timeout=1m30s&ratio=0.25&debug=true&ids=1&ids=2&code=abc&ip=203.0.113.7&limit=5"
+	code, bound := bindRequest(t, &bindTestParams{}, httptest.NewRequest(http.MethodGet, "/?"+query, nil))
+	if code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	params := bound.(*bindTestParams)
+	limit := 5
+	expected := &bindTestParams{
+		Since:   time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC),
+		At:      time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC),
+		Timeout: 90 * time.Second,
+		Ratio:   0.25,
+		Debug:   true,
+		IDs:     []int{1, 2},
+		Code:    "ABC",
+		IP:      net.ParseIP("203.0.113.7"),
+		Limit:   &limit,
+	}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("bound %+v, want %+v", params, expected)
+	}
+}
+
+func TestBindQuerySlices(t *testing.T) {
+	cases := map[string][]int{
+		"ids=1&ids=2":     {1, 2},
+		"ids[]=1&ids[]=2": {1, 2},
+		"ids=1,2,3":       {1, 2, 3},
+		"ids[]=1&ids=2":   {1, 2},
+		"":                nil,
+	}
+	for query, ids := range cases {
+		code, bound := bindRequest(t, &bindTestParams{}, httptest.NewRequest(http.MethodGet, "/?"+query, nil))
+		if code != http.StatusOK {
+			t.Errorf("?%s: status %d", query, code)
+			continue
+		}
+		if got := bound.(*bindTestParams).IDs; !reflect.DeepEqual(got, ids) {
+			t.Errorf("?%s: ids %v, want %v", query, got, ids)
+		}
+	}
+}
+
+func TestBindErrors(t *testing.T) {
+	cases := map[string]string{
+		"since=06/01/2020":   "since",
+		"at=2020-06-01":      "at",
+		"timeout=soon":       "timeout",
+		"ratio=half":         "ratio",
+		"debug=maybe":        "debug",
+		"ids=1,two":          "ids",
+		"code=":              "code",
+		"limit=99999999999x": "limit",
+	}
+	for query, field := range cases {
+		w := httptest.NewRecorder()
+		Bind(&bindTestParams{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("?%s: handler reached", query)
+		})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("?%s: status %d", query, w.Code)
+			continue
+		}
+		var details problem.Details
+		if err := json.Unmarshal(w.Body.Bytes(), &details); err != nil {
+			t.Fatal(err)
+		}
+		if len(details.InvalidParams) != 1 || details.InvalidParams[0].Name != field {
+			t.Errorf("?%s: invalid params %+v, want %s", query, details.InvalidParams, field)
+		}
+	}
+
+	var bindErr *BindError
+	err := bindValues(httptest.NewRequest(http.MethodGet, "/?timeout=soon", nil), reflect.ValueOf(&bindTestParams{}).Elem(), nil, make(presence))
+	if !errors.As(err, &bindErr) || bindErr.Field != "timeout" || bindErr.Source != SourceQuery || bindErr.Err == nil {
+		t.Errorf("error %#v", err)
+	}
+}
+
+type bindTestForm struct {
+	Name  string   `form:"name"`
+	Tags  []string `form:"tags"`
+	Admin bool     `form:"admin"`
+}
+
+func TestBindForm(t *testing.T) {
+	form := url.Values{"name": {"jane"}, "tags": {"a", "b"}, "admin": {"1"}}
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	code, bound := bindRequest(t, &bindTestForm{}, r)
+	if code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	expected := &bindTestForm{Name: "jane", Tags: []string{"a", "b"}, Admin: true}
+	if !reflect.DeepEqual(bound, expected) {
+		t.Errorf("bound %+v, want %+v", bound, expected)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("admin=sometimes"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if code, _ := bindRequest(t, &bindTestForm{}, r); code != http.StatusBadRequest {
+		t.Errorf("malformed bool: status %d", code)
+	}
+}
+
+type bindTestPath struct {
+	ID   int    `path:"id"`
+	Slug string `path:"slug"`
+}
+
+func TestBindPath(t *testing.T) {
+	var bound *bindTestPath
+	router := chi.NewRouter()
+	router.With(Bind(&bindTestPath{})).Get("/posts/{id}/{slug}", func(w http.ResponseWriter, r *http.Request) {
+		bound = Bound(r).(*bindTestPath)
+	})
+
+	if code := serve(router, httptest.NewRequest(http.MethodGet, "/posts/42/hello-world", nil)); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if bound.ID != 42 || bound.Slug != "hello-world" {
+		t.Errorf("bound %+v", bound)
+	}
+	if code := serve(router, httptest.NewRequest(http.MethodGet, "/posts/abc/hello-world", nil)); code != http.StatusBadRequest {
+		t.Errorf("non numeric id: status %d", code)
+	}
+}
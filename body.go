@@ -0,0 +1,229 @@
+package middlewares
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/go-errors/errors"
+)
+
+var (
+	ErrBodyTooLarge        = errors.New("request body is too large")
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+)
+
+type bodyConfig struct {
+	maxSize        int64
+	spillThreshold int64
+	spillDir       string
+	decompress     bool
+}
+
+// BodyOption configures StoreBodyInContext
+type BodyOption func(*bodyConfig)
+
+// MaxBodySize rejects bodies larger than size bytes with 413. When the body
+// is decompressed the limit applies to the decompressed content.
+func MaxBodySize(size int64) BodyOption {
+	return func(c *bodyConfig) {
+		c.maxSize = size
+	}
+}
+
+// SpillToDisk keeps bodies larger than threshold bytes in a temporary file in
+// dir instead of memory. An empty dir means os.TempDir.
+//
+// Only readers that stream the file benefit: handlers calling OpenBody or
+// reading r.Body, and VerifyDigest. Middlewares that need the whole content
+// read it back into memory, namely RequireParametersInBody, Bind,
+// RequireJSONSchema, the webhook verifiers and signing strings built from
+// GetBodyContent, so keep MaxBodySize low enough for them.
+func SpillToDisk(threshold int64, dir string) BodyOption {
+	return func(c *bodyConfig) {
+		c.spillThreshold = threshold
+		c.spillDir = dir
+	}
+}
+
+// DecompressBody decodes gzip, deflate and br Content-Encoding before the body
+// is stored. Content-Encoding is removed from the request afterwards. The
+// encoded bytes are hashed on the way so VerifyDigest, which covers the body
+// as sent, still works further down the chain.
+func DecompressBody() BodyOption {
+	return func(c *bodyConfig) {
+		c.decompress = true
+	}
+}
+
+// spilledBody is stored in context instead of the content when the body was
+// written to disk
+type spilledBody struct {
+	path string
+	size int64
+}
+
+func contentEncoding(r *http.Request) string {
+	return strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+}
+
+// wireHashes hashes the body of a request as it is read, before it is
+// decompressed
+type wireHashes struct {
+	body   io.Reader
+	hashes map[string]hash.Hash
+}
+
+// hashWire makes r.Body feed the digest algorithms supported by VerifyDigest
+func hashWire(r *http.Request) *wireHashes {
+	wire := &wireHashes{hashes: make(map[string]hash.Hash, len(digestAlgorithms))}
+	writers := make([]io.Writer, 0, len(digestAlgorithms))
+	for algorithm, newHash := range digestAlgorithms {
+		wire.hashes[algorithm] = newHash()
+		writers = append(writers, wire.hashes[algorithm])
+	}
+	wire.body = io.TeeReader(r.Body, io.MultiWriter(writers...))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{wire.body, r.Body}
+	return wire
+}
+
+// sums reads what the decoder left of the body, at most limit bytes when
+// limit is positive, and returns the digest of every algorithm
+func (wire *wireHashes) sums(limit int64) (map[string][]byte, error) {
+	rest := wire.body
+	if limit > 0 {
+		rest = io.LimitReader(rest, limit+1)
+	}
+	if n, err := io.Copy(ioutil.Discard, rest); err != nil {
+		return nil, err
+	} else if limit > 0 && n > limit {
+		return nil, ErrBodyTooLarge
+	}
+	sums := make(map[string][]byte, len(wire.hashes))
+	for algorithm, hasher := range wire.hashes {
+		sums[algorithm] = hasher.Sum(nil)
+	}
+	return sums, nil
+}
+
+func (config bodyConfig) decoder(r *http.Request) (io.Reader, func() error, error) {
+	nop := func() error { return nil }
+	if !config.decompress {
+		return r.Body, nop, nil
+	}
+
+	switch contentEncoding(r) {
+	case "", "identity":
+		return r.Body, nop, nil
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, nop, err
+		}
+		return reader, reader.Close, nil
+	case "deflate":
+		reader := flate.NewReader(r.Body)
+		return reader, reader.Close, nil
+	case "br":
+		return brotli.NewReader(r.Body), nop, nil
+	}
+	return nil, nop, ErrUnsupportedEncoding
+}
+
+// read consumes the body of r. It returns the content when it fits in
+// memory, or the spilled file otherwise.
+func (config bodyConfig) read(r *http.Request) ([]byte, *spilledBody, int, error) {
+	source, closeSource, err := config.decoder(r)
+	if err == ErrUnsupportedEncoding {
+		return nil, nil, http.StatusUnsupportedMediaType, err
+	}
+	if err != nil {
+		return nil, nil, http.StatusBadRequest, err
+	}
+	defer closeSource()
+
+	if config.maxSize > 0 {
+		source = io.LimitReader(source, config.maxSize+1)
+	}
+
+	var buf bytes.Buffer
+	inMemory := source
+	if config.spillThreshold > 0 {
+		inMemory = io.LimitReader(source, config.spillThreshold+1)
+	}
+	size, err := buf.ReadFrom(inMemory)
+	if err != nil {
+		return nil, nil, http.StatusBadRequest, err
+	}
+	if config.maxSize > 0 && size > config.maxSize {
+		return nil, nil, http.StatusRequestEntityTooLarge, ErrBodyTooLarge
+	}
+	if config.spillThreshold <= 0 || size <= config.spillThreshold {
+		return buf.Bytes(), nil, 0, nil
+	}
+
+	tmp, err := ioutil.TempFile(config.spillDir, "body-")
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, err
+	}
+	defer tmp.Close()
+	spilled := &spilledBody{path: tmp.Name()}
+
+	written, err := io.Copy(tmp, io.MultiReader(&buf, source))
+	if err != nil {
+		_ = os.Remove(spilled.path)
+		return nil, nil, http.StatusBadRequest, err
+	}
+	if config.maxSize > 0 && written > config.maxSize {
+		_ = os.Remove(spilled.path)
+		return nil, nil, http.StatusRequestEntityTooLarge, ErrBodyTooLarge
+	}
+	spilled.size = written
+	return nil, spilled, 0, nil
+}
+
+// OpenBody returns a fresh reader over the body saved by StoreBodyInContext,
+// whether it was kept in memory or spilled to disk
+func OpenBody(r *http.Request) (io.ReadCloser, error) {
+	switch body := r.Context().Value(HttpBodyKey).(type) {
+	case *spilledBody:
+		return os.Open(body.path)
+	case nil:
+		return nil, errors.New("body was not stored in context")
+	default:
+		return ioutil.NopCloser(bytes.NewReader(GetBodyContent(r))), nil
+	}
+}
+
+// readBody returns the body saved by StoreBodyInContext, or reads it and puts
+// back a reader over the content so handlers further down can read it again.
+// A spilled body is loaded in memory, see SpillToDisk.
+func readBody(r *http.Request) ([]byte, error) {
+	if buf := GetBodyContent(r); buf != nil {
+		return buf, nil
+	}
+	buf, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	restoreBody(r, buf)
+	return buf, nil
+}
+
+func restoreBody(r *http.Request, buf []byte) {
+	r.Body = ioutil.NopCloser(bytes.NewReader(buf))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf)), nil
+	}
+	r.ContentLength = int64(len(buf))
+}
@@ -0,0 +1,103 @@
+package middlewares
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// storedBody runs r through StoreBodyInContext and returns the status, what
+// the handler read from r.Body and from GetBodyContent
+func storedBody(r *http.Request, options ...BodyOption) (int, string, string) {
+	var read, stored string
+	handler := StoreBodyInContext(options...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, _ := ioutil.ReadAll(r.Body)
+		read, stored = string(content), string(GetBodyContent(r))
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Code, read, stored
+}
+
+func gzipped(t *testing.T, content string) *bytes.Buffer {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+	return &buf
+}
+
+func TestStoreBodyInContext(t *testing.T) {
+	code, read, stored := storedBody(httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello")))
+	if code != http.StatusOK || read != "hello" || stored != "hello" {
+		t.Errorf("status %d, read %q, stored %q", code, read, stored)
+	}
+
+	code, _, _ = storedBody(httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello world")), MaxBodySize(5))
+	if code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body: status %d", code)
+	}
+	code, _, stored = storedBody(httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello")), MaxBodySize(5))
+	if code != http.StatusOK || stored != "hello" {
+		t.Errorf("body at the limit: status %d, stored %q", code, stored)
+	}
+}
+
+func TestStoreBodyDecompress(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", gzipped(t, "hello"))
+	r.Header.Set("Content-Encoding", "gzip")
+	code, read, stored := storedBody(r, DecompressBody())
+	if code != http.StatusOK || read != "hello" || stored != "hello" {
+		t.Errorf("status %d, read %q, stored %q", code, read, stored)
+	}
+
+	// the limit applies to the decompressed content, so bombs are stopped
+	r = httptest.NewRequest(http.MethodPost, "/", gzipped(t, strings.Repeat("a", 1<<20)))
+	r.Header.Set("Content-Encoding", "gzip")
+	if code, _, _ := storedBody(r, DecompressBody(), MaxBodySize(1<<10)); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("compressed bomb: status %d", code)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+	r.Header.Set("Content-Encoding", "compress")
+	if code, _, _ := storedBody(r, DecompressBody()); code != http.StatusUnsupportedMediaType {
+		t.Errorf("unsupported encoding: status %d", code)
+	}
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("not gzip"))
+	r.Header.Set("Content-Encoding", "gzip")
+	if code, _, _ := storedBody(r, DecompressBody()); code != http.StatusBadRequest {
+		t.Errorf("corrupt gzip: status %d", code)
+	}
+}
+
+func TestStoreBodySpillToDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "body")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	body := strings.Repeat("0123456789", 10)
+	code, read, stored := storedBody(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)), SpillToDisk(16, dir))
+	if code != http.StatusOK || read != body || stored != body {
+		t.Errorf("status %d, read %q, stored %q", code, read, stored)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("%d spilled files left behind", len(files))
+	}
+
+	code, _, _ = storedBody(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)), SpillToDisk(16, dir), MaxBodySize(50))
+	if code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized spilled body: status %d", code)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("%d spilled files left behind after rejection", len(files))
+	}
+}
@@ -0,0 +1,298 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/jeffguorg/middlewares/problem"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec decodes a request body into parameters. params holds the media type
+// parameters of Content-Type, such as the multipart boundary.
+type Codec interface {
+	Decode(body []byte, params map[string]string) (map[string]interface{}, error)
+}
+
+// CodecFunc adapts a function to Codec
+type CodecFunc func(body []byte, params map[string]string) (map[string]interface{}, error)
+
+// Decode calls f(body, params)
+func (f CodecFunc) Decode(body []byte, params map[string]string) (map[string]interface{}, error) {
+	return f(body, params)
+}
+
+var (
+	codecsLock sync.RWMutex
+	codecs     = map[string]Codec{
+		"application/json":                  CodecFunc(decodeJSON),
+		"application/x-www-form-urlencoded": CodecFunc(decodeURLEncoded),
+		"multipart/form-data":               CodecFunc(decodeMultipart),
+		"application/xml":                   CodecFunc(decodeXML),
+		"text/xml":                          CodecFunc(decodeXML),
+		"application/msgpack":               CodecFunc(decodeMsgpack),
+		"application/x-msgpack":             CodecFunc(decodeMsgpack),
+		"application/vnd.msgpack":           CodecFunc(decodeMsgpack),
+		"application/cbor":                  CodecFunc(decodeCBOR),
+	}
+)
+
+// RegisterCodec makes RequireParametersInBody and Bind decode mediaType with
+// codec, replacing any codec registered for it before
+func RegisterCodec(mediaType string, codec Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	codecs[strings.ToLower(mediaType)] = codec
+}
+
+// LookupCodec returns the codec registered for mediaType. Structured syntax
+// suffixes fall back to their base codec, e.g. application/problem+json is
+// decoded as application/json.
+func LookupCodec(mediaType string) (Codec, bool) {
+	mediaType = strings.ToLower(mediaType)
+
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	if codec, ok := codecs[mediaType]; ok {
+		return codec, true
+	}
+	if idx := strings.LastIndexByte(mediaType, '+'); idx >= 0 {
+		codec, ok := codecs["application/"+mediaType[idx+1:]]
+		return codec, ok
+	}
+	return nil, false
+}
+
+// decodeBody decodes the body of r with the codec registered for its
+// Content-Type. Requests without one are decoded as defaultMediaType, or
+// left alone when it is empty. The returned status goes with err.
+func decodeBody(r *http.Request, defaultMediaType string) (map[string]interface{}, string, int, error) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		if defaultMediaType == "" {
+			return nil, "", 0, nil
+		}
+		contentType = defaultMediaType
+	}
+	mediaType, mediaParams, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, "", http.StatusUnsupportedMediaType, fmt.Errorf("invalid Content-Type: %v", err)
+	}
+	codec, ok := LookupCodec(mediaType)
+	if !ok {
+		return nil, mediaType, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported Content-Type %s", mediaType)
+	}
+
+	buf, err := readBody(r)
+	if err != nil {
+		return nil, mediaType, http.StatusInternalServerError, err
+	}
+	if len(buf) == 0 {
+		return map[string]interface{}{}, mediaType, 0, nil
+	}
+	values, err := codec.Decode(buf, mediaParams)
+	if err != nil {
+		return nil, mediaType, http.StatusBadRequest, fmt.Errorf("malformed %s body: %v", mediaType, err)
+	}
+	return values, mediaType, 0, nil
+}
+
+// bodySource tells parameters of JSON bodies apart from other bodies
+func bodySource(mediaType string) Source {
+	if mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") {
+		return SourceJSON
+	}
+	return SourceBody
+}
+
+// RequireParametersInBody decodes the body with the codec registered for its
+// Content-Type, checks keys and stores every top level member in context.
+// Keys may be dotted paths into nested members and carry validation rules,
+// see parameterSpec for the syntax. Bodies without Content-Type are decoded
+// as JSON, unknown media types are rejected with 415.
+func RequireParametersInBody(keys ...string) func(http.Handler) http.Handler {
+	specs := mustParseParameterSpecs(keys)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			values, mediaType, status, err := decodeBody(r, "application/json")
+			if err != nil {
+				problem.Respond(w, r, status, err)
+				return
+			}
+
+			var errs ValidationError
+			for _, spec := range specs {
+				value, ok := lookupPath(values, spec.key)
+				errs = spec.check(value, ok, errs)
+			}
+			if len(errs) > 0 {
+				problem.Respond(w, r, http.StatusBadRequest, errs)
+				return
+			}
+
+			source := bodySource(mediaType)
+			params := make(parameterStore, len(values))
+			for k, v := range values {
+				param := storedParameter{Value: v, Source: source}
+				if list, ok := v.([]string); ok {
+					param.Value = list[0]
+					param.Values = list
+				}
+				params[k] = param
+			}
+			next.ServeHTTP(w, r.WithContext(withParameters(r, params)))
+		})
+	}
+}
+
+func decodeJSON(body []byte, _ map[string]string) (map[string]interface{}, error) {
+	var values map[string]interface{}
+	err := json.Unmarshal(body, &values)
+	return values, err
+}
+
+func decodeURLEncoded(body []byte, _ map[string]string) (map[string]interface{}, error) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	return formValues(form), nil
+}
+
+func decodeMultipart(body []byte, params map[string]string) (map[string]interface{}, error) {
+	form, err := multipart.NewReader(bytes.NewReader(body), params["boundary"]).ReadForm(defaultMaxMemory)
+	if err != nil {
+		return nil, err
+	}
+	defer form.RemoveAll()
+	return formValues(form.Value), nil
+}
+
+// formValues keeps single values as strings and repeated ones as []string
+func formValues(form url.Values) map[string]interface{} {
+	values := make(map[string]interface{}, len(form))
+	for k, v := range form {
+		if len(v) == 1 {
+			values[k] = v[0]
+		} else {
+			values[k] = v
+		}
+	}
+	return values
+}
+
+func decodeMsgpack(body []byte, _ map[string]string) (map[string]interface{}, error) {
+	var values map[string]interface{}
+	if err := msgpack.Unmarshal(body, &values); err != nil {
+		return nil, err
+	}
+	return normalizeMap(values), nil
+}
+
+func decodeCBOR(body []byte, _ map[string]string) (map[string]interface{}, error) {
+	var values map[string]interface{}
+	if err := cbor.Unmarshal(body, &values); err != nil {
+		return nil, err
+	}
+	return normalizeMap(values), nil
+}
+
+// normalizeMap turns the map[interface{}]interface{} some decoders produce
+// for nested maps into map[string]interface{} so paths can be looked up
+func normalizeMap(values map[string]interface{}) map[string]interface{} {
+	for k, v := range values {
+		values[k] = normalize(v)
+	}
+	return values
+}
+
+func normalize(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		return normalizeMap(value)
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			result[fmt.Sprint(k)] = normalize(item)
+		}
+		return result
+	case []interface{}:
+		for i, item := range value {
+			value[i] = normalize(item)
+		}
+		return value
+	}
+	return v
+}
+
+// decodeXML maps the children of the root element to parameters. Elements
+// with children become nested maps, repeated elements become lists and
+// attributes are ignored.
+func decodeXML(body []byte, _ map[string]string) (map[string]interface{}, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			value, err := decodeXMLElement(decoder, start)
+			if err != nil {
+				return nil, err
+			}
+			if values, ok := value.(map[string]interface{}); ok {
+				return values, nil
+			}
+			return map[string]interface{}{}, nil
+		}
+	}
+}
+
+func decodeXMLElement(decoder *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	var children map[string]interface{}
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			child, err := decodeXMLElement(decoder, t)
+			if err != nil {
+				return nil, err
+			}
+			if children == nil {
+				children = make(map[string]interface{})
+			}
+			name := t.Name.Local
+			switch existing := children[name].(type) {
+			case nil:
+				children[name] = child
+			case []interface{}:
+				children[name] = append(existing, child)
+			default:
+				children[name] = []interface{}{existing, child}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if children != nil {
+				return children, nil
+			}
+			return strings.TrimSpace(text.String()), nil
+		}
+	}
+}
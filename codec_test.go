@@ -0,0 +1,207 @@
+package middlewares
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type codecTestParams struct {
+	Name string   `json:"name"`
+	Age  int      `json:"age"`
+	Tags []string `json:"tags"`
+}
+
+func bindRequest(t *testing.T, v interface{}, r *http.Request) (int, interface{}) {
+	var bound interface{}
+	handler := Bind(v)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bound = Bound(r)
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Code, bound
+}
+
+func TestBindCodecs(t *testing.T) {
+	packed, err := msgpack.Marshal(map[string]interface{}{"name": "jane", "age": 42, "tags": []string{"a", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodies := map[string]string{
+		"application/json":         `{"name":"jane","age":42,"tags":["a","b"]}`,
+		"application/problem+json": `{"name":"jane","age":42,"tags":["a","b"]}`,
+		"application/xml":          `<params><name>jane</name><age>42</age><tags>a</tags><tags>b</tags></params>`,
+		"application/msgpack":      string(packed),
+	}
+	for contentType, body := range bodies {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		code, bound := bindRequest(t, &codecTestParams{}, r)
+		if code != http.StatusOK {
+			t.Errorf("%s: status %d", contentType, code)
+			continue
+		}
+		params := bound.(*codecTestParams)
+		if params.Name != "jane" || params.Age != 42 || strings.Join(params.Tags, ",") != "a,b" {
+			t.Errorf("%s: bound %+v", contentType, params)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("name=jane"))
+	r.Header.Set("Content-Type", "text/plain")
+	if code, _ := bindRequest(t, &codecTestParams{}, r); code != http.StatusUnsupportedMediaType {
+		t.Errorf("text/plain: status %d, want 415", code)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`<params><age>old</age></params>`))
+	r.Header.Set("Content-Type", "application/xml")
+	if code, _ := bindRequest(t, &codecTestParams{}, r); code != http.StatusBadRequest {
+		t.Errorf("invalid XML value: status %d, want 400", code)
+	}
+}
+
+func TestRequireParametersInJSONCodecs(t *testing.T) {
+	cases := []struct {
+		contentType string
+		body        string
+		status      int
+	}{
+		{"", `{"name":"jane"}`, http.StatusOK},
+		{"application/json", `{"name":"jane"}`, http.StatusOK},
+		{"application/xml", `<params><name>jane</name></params>`, http.StatusOK},
+		{"application/x-www-form-urlencoded", `name=jane`, http.StatusOK},
+		{"application/json", `{"other":"jane"}`, http.StatusBadRequest},
+		{"application/json", `{"name":`, http.StatusBadRequest},
+		{"text/plain", `name=jane`, http.StatusUnsupportedMediaType},
+	}
+	for _, c := range cases {
+		var name interface{}
+		handler := RequireParametersInJSON("name")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			name = Parameter(r, "name")
+		}))
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte(c.body)))
+		if c.contentType != "" {
+			r.Header.Set("Content-Type", c.contentType)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("%q %s: status %d, want %d", c.contentType, c.body, w.Code, c.status)
+		}
+		if c.status == http.StatusOK && name != "jane" {
+			t.Errorf("%q: name %v", c.contentType, name)
+		}
+	}
+}
+
+func TestRegisterCodec(t *testing.T) {
+	const mediaType = "application/x-pairs"
+	// decodes "k=v;k=v" bodies, with the separator as a media type parameter
+	RegisterCodec("Application/X-Pairs", CodecFunc(func(body []byte, params map[string]string) (map[string]interface{}, error) {
+		separator := params["separator"]
+		if separator == "" {
+			separator = ";"
+		}
+		values := make(map[string]interface{})
+		for _, pair := range strings.Split(string(body), separator) {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) == 2 {
+				values[kv[0]] = kv[1]
+			}
+		}
+		return values, nil
+	}))
+	defer func() {
+		codecsLock.Lock()
+		delete(codecs, mediaType)
+		codecsLock.Unlock()
+	}()
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("name=jane|age=42"))
+	r.Header.Set("Content-Type", mediaType+"; separator=|")
+	code, bound := bindRequest(t, &codecTestParams{}, r)
+	if code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if params := bound.(*codecTestParams); params.Name != "jane" || params.Age != 42 {
+		t.Errorf("bound %+v", params)
+	}
+}
+
+func TestLookupCodec(t *testing.T) {
+	cases := map[string]string{
+		"application/json":           "application/json",
+		"APPLICATION/JSON":           "application/json",
+		"application/vnd.api+json":   "application/json",
+		"application/problem+json":   "application/json",
+		"application/atom+xml":       "application/xml",
+		"application/vnd.thing+cbor": "application/cbor",
+		"application/vnd.thing+yaml": "",
+		"text/plain":                 "",
+	}
+	for mediaType, base := range cases {
+		codec, ok := LookupCodec(mediaType)
+		if ok != (base != "") {
+			t.Errorf("%s: found %v", mediaType, ok)
+			continue
+		}
+		if ok && reflect.ValueOf(codec).Pointer() != reflect.ValueOf(codecs[base]).Pointer() {
+			t.Errorf("%s: not decoded as %s", mediaType, base)
+		}
+	}
+}
+
+func TestCBORCodec(t *testing.T) {
+	body, err := cbor.Marshal(map[string]interface{}{"name": "jane", "age": 42, "tags": []string{"a", "b"}, "meta": map[string]interface{}{"zip": "75001"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var zip interface{}
+	handler := RequireParametersInBody("name", "meta.zip")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		zip, _ = ParameterPath(r, "meta.zip")
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/cbor")
+	if code := serve(handler, r); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if zip != "75001" {
+		t.Errorf("meta.zip = %v", zip)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/cbor")
+	code, bound := bindRequest(t, &codecTestParams{}, r)
+	expected := &codecTestParams{Name: "jane", Age: 42, Tags: []string{"a", "b"}}
+	if code != http.StatusOK || !reflect.DeepEqual(bound, expected) {
+		t.Errorf("status %d, bound %+v", code, bound)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("\xff\x00"))
+	r.Header.Set("Content-Type", "application/cbor")
+	if code := serve(handler, r); code != http.StatusBadRequest {
+		t.Errorf("malformed CBOR: status %d", code)
+	}
+}
+
+func TestUnknownContentType(t *testing.T) {
+	handlers := map[string]http.Handler{
+		"RequireParametersInBody": RequireParametersInBody("name")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})),
+		"Bind":                    Bind(&codecTestParams{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})),
+	}
+	for name, handler := range handlers {
+		for _, contentType := range []string{"text/csv", "application/vnd.thing+yaml", "not a media type;"} {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("name=jane"))
+			r.Header.Set("Content-Type", contentType)
+			if code := serve(handler, r); code != http.StatusUnsupportedMediaType {
+				t.Errorf("%s %q: status %d, want 415", name, contentType, code)
+			}
+		}
+	}
+}
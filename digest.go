@@ -0,0 +1,221 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/jeffguorg/middlewares/problem"
+)
+
+const (
+	DigestSHA256 = "sha-256"
+	DigestSHA512 = "sha-512"
+
+	wireDigestsCtxKey = "IsylLzqZ.wireDigests"
+)
+
+var (
+	ErrDigestMissing  = errors.New("body digest is missing")
+	ErrDigestMismatch = errors.New("body does not match its digest")
+)
+
+var digestAlgorithms = map[string]func() hash.Hash{
+	DigestSHA256: sha256.New,
+	DigestSHA512: sha512.New,
+}
+
+// bodyDigest is one digest sent in header
+type bodyDigest struct {
+	header    string
+	algorithm string
+	digest    []byte
+}
+
+// parseDigests reads the supported algorithms of Content-Digest (RFC 9530,
+// sha-256=:base64:) and of the legacy Digest header (RFC 3230,
+// SHA-256=base64). Unsupported algorithms are skipped. Every value is kept,
+// so one header cannot shadow another for the same algorithm.
+func parseDigests(r *http.Request) ([]bodyDigest, error) {
+	var digests []bodyDigest
+	for _, header := range []string{"Content-Digest", "Digest"} {
+		for _, value := range r.Header.Values(header) {
+			for _, member := range strings.Split(value, ",") {
+				kv := strings.SplitN(strings.TrimSpace(member), "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				algorithm := strings.ToLower(kv[0])
+				if _, ok := digestAlgorithms[algorithm]; !ok {
+					continue
+				}
+				encoded := kv[1]
+				if header == "Content-Digest" {
+					if len(encoded) < 2 || encoded[0] != ':' || encoded[len(encoded)-1] != ':' {
+						return nil, fmt.Errorf("%s is malformed", header)
+					}
+					encoded = encoded[1 : len(encoded)-1]
+				}
+				digest, err := base64.StdEncoding.Strict().DecodeString(encoded)
+				if err != nil {
+					return nil, fmt.Errorf("%s is malformed", header)
+				}
+				digests = append(digests, bodyDigest{header: header, algorithm: algorithm, digest: digest})
+			}
+		}
+	}
+	return digests, nil
+}
+
+// openDigestBody streams the body kept by StoreBodyInContext, or buffers it
+func openDigestBody(r *http.Request) (io.ReadCloser, error) {
+	if r.Context().Value(HttpBodyKey) != nil {
+		return OpenBody(r)
+	}
+	body, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(body)), nil
+}
+
+// bodyDigests hashes the body with the algorithms used by digests
+func bodyDigests(r *http.Request, digests []bodyDigest) (map[string][]byte, error) {
+	body, err := openDigestBody(r)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	hashes := make(map[string]hash.Hash, len(digestAlgorithms))
+	writers := make([]io.Writer, 0, len(digestAlgorithms))
+	for _, digest := range digests {
+		if _, ok := hashes[digest.algorithm]; !ok {
+			hashes[digest.algorithm] = digestAlgorithms[digest.algorithm]()
+			writers = append(writers, hashes[digest.algorithm])
+		}
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), body); err != nil {
+		return nil, err
+	}
+	sums := make(map[string][]byte, len(hashes))
+	for algorithm, hasher := range hashes {
+		sums[algorithm] = hasher.Sum(nil)
+	}
+	return sums, nil
+}
+
+// VerifyDigest checks the Content-Digest and Digest headers of requests
+// against the body, using sha-256 and sha-512. Every supported digest sent
+// in either header must match. Requests without one are rejected when required, with a
+// Want-Content-Digest header telling the client what to send.
+//
+// The digest covers the body as sent. When StoreBodyInContext decompressed
+// it, the digests it took of the encoded bytes are checked instead.
+func VerifyDigest(required bool) func(handler http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			digests, err := parseDigests(r)
+			if err != nil {
+				problem.Respond(w, r, http.StatusBadRequest, err)
+				return
+			}
+			if len(digests) == 0 {
+				if required {
+					w.Header().Set("Want-Content-Digest", DigestSHA256+"=10, "+DigestSHA512+"=5")
+					problem.Respond(w, r, http.StatusBadRequest, ErrDigestMissing)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			sums, ok := r.Context().Value(wireDigestsCtxKey).(map[string][]byte)
+			if !ok {
+				if sums, err = bodyDigests(r, digests); err != nil {
+					problem.Respond(w, r, http.StatusBadRequest, err)
+					return
+				}
+			}
+			for _, digest := range digests {
+				if !hmac.Equal(sums[digest.algorithm], digest.digest) {
+					problem.Respond(w, r, http.StatusBadRequest, fmt.Errorf("%w: %s %s", ErrDigestMismatch, digest.header, digest.algorithm))
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// bufferedResponse holds the status and body of a response until the handler
+// returns. Headers go straight to the underlying writer's map.
+type bufferedResponse struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedResponse) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *bufferedResponse) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(p)
+}
+
+// flush sends the buffered status and body
+func (w *bufferedResponse) flush() {
+	w.ResponseWriter.WriteHeader(w.status)
+	_, _ = w.ResponseWriter.Write(w.body.Bytes())
+}
+
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// AddContentDigest adds a Content-Digest header with the given algorithms,
+// sha-256 when none, to responses. Responses are buffered to be hashed,
+// handlers that set Content-Digest themselves are left alone.
+func AddContentDigest(algorithms ...string) func(handler http.Handler) http.Handler {
+	if len(algorithms) == 0 {
+		algorithms = []string{DigestSHA256}
+	}
+	for _, algorithm := range algorithms {
+		if _, ok := digestAlgorithms[algorithm]; !ok {
+			panic("middlewares: unsupported digest algorithm " + algorithm)
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			buffered := &bufferedResponse{ResponseWriter: w}
+			next.ServeHTTP(buffered, r)
+			buffered.WriteHeader(http.StatusOK)
+
+			if w.Header().Get("Content-Digest") == "" && bodyAllowed(buffered.status) && r.Method != http.MethodHead {
+				members := make([]string, 0, len(algorithms))
+				for _, algorithm := range algorithms {
+					hasher := digestAlgorithms[algorithm]()
+					hasher.Write(buffered.body.Bytes())
+					members = append(members, algorithm+"=:"+base64.StdEncoding.EncodeToString(hasher.Sum(nil))+":")
+				}
+				w.Header().Set("Content-Digest", strings.Join(members, ", "))
+			}
+			buffered.flush()
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package middlewares
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func sha256Base64(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func digestRequest(t *testing.T, required bool, body string, headers map[string]string) (int, bool) {
+	reached := false
+	handler := VerifyDigest(required)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Code, reached
+}
+
+func TestVerifyDigest(t *testing.T) {
+	cases := []struct {
+		name     string
+		required bool
+		headers  map[string]string
+		reached  bool
+	}{
+		{"content-digest", true, map[string]string{"Content-Digest": "sha-256=:" + sha256Base64("original") + ":"}, true},
+		{"legacy digest", true, map[string]string{"Digest": "SHA-256=" + sha256Base64("original")}, true},
+		{"both headers", true, map[string]string{
+			"Content-Digest": "sha-256=:" + sha256Base64("original") + ":",
+			"Digest":         "SHA-256=" + sha256Base64("original"),
+		}, true},
+		{"missing optional", false, nil, true},
+		{"missing required", true, nil, false},
+		{"tampered", true, map[string]string{"Content-Digest": "sha-256=:" + sha256Base64("tampered") + ":"}, false},
+		{"malformed", true, map[string]string{"Content-Digest": "sha-256=" + sha256Base64("original")}, false},
+		// a matching legacy Digest must not stand in for a mismatching
+		// Content-Digest covered by a signature
+		{"disagreeing headers", true, map[string]string{
+			"Content-Digest": "sha-256=:" + sha256Base64("tampered") + ":",
+			"Digest":         "SHA-256=" + sha256Base64("original"),
+		}, false},
+		{"disagreeing legacy header", true, map[string]string{
+			"Content-Digest": "sha-256=:" + sha256Base64("original") + ":",
+			"Digest":         "SHA-256=" + sha256Base64("tampered"),
+		}, false},
+	}
+	for _, c := range cases {
+		code, reached := digestRequest(t, c.required, "original", c.headers)
+		if reached != c.reached {
+			t.Errorf("%s: reached handler %v with status %d", c.name, reached, code)
+		}
+		if !reached && code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", c.name, code)
+		}
+	}
+}
+
+func TestAddContentDigest(t *testing.T) {
+	handler := AddContentDigest()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got, want := w.Header().Get("Content-Digest"), "sha-256=:"+sha256Base64("hello")+":"; got != want {
+		t.Errorf("Content-Digest %q, want %q", got, want)
+	}
+	if w.Body.String() != "hello" {
+		t.Errorf("body %q", w.Body.String())
+	}
+}
+
+func TestVerifyDigestAfterDecompression(t *testing.T) {
+	var content string
+	handler := StoreBodyInContext(DecompressBody())(VerifyDigest(true)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content = string(GetBodyContent(r))
+	})))
+	encoded := gzipped(t, "original").String()
+
+	cases := map[string]struct {
+		digest string
+		status int
+	}{
+		"encoded bytes":   {sha256Base64(encoded), http.StatusOK},
+		"decoded content": {sha256Base64("original"), http.StatusBadRequest},
+	}
+	for name, c := range cases {
+		content = ""
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(encoded))
+		r.Header.Set("Content-Encoding", "gzip")
+		r.Header.Set("Content-Digest", "sha-256=:"+c.digest+":")
+		if code := serve(handler, r); code != c.status {
+			t.Errorf("%s: status %d, want %d", name, code, c.status)
+		}
+		if c.status == http.StatusOK && content != "original" {
+			t.Errorf("%s: handler read %q", name, content)
+		}
+	}
+}
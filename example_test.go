@@ -6,7 +6,7 @@ import (
 	"net/http"
 )
 
-func ExampleUseMiddleware() {
+func Example() {
 	router := chi.NewRouter()
 
 	router.Get("/", func(w http.ResponseWriter, r *http.Request) {
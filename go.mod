@@ -7,8 +7,10 @@ require (
 	github.com/Azure/go-autorest/autorest v0.10.1 // indirect
 	github.com/Azure/go-autorest/autorest/adal v0.8.3 // indirect
 	github.com/Azure/go-autorest/autorest/to v0.3.0 // indirect
+	github.com/andybalholm/brotli v1.1.0
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/dnaeon/go-vcr v1.0.1 // indirect
+	github.com/fxamacker/cbor/v2 v2.2.0
 	github.com/getsentry/sentry-go v0.6.1
 	github.com/go-chi/chi v4.1.1+incompatible
 	github.com/go-errors/errors v1.0.2
@@ -16,7 +18,9 @@ require (
 	github.com/google/uuid v1.1.1
 	github.com/json-iterator/go v1.1.9
 	github.com/pkg/errors v0.9.1
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.0
 	github.com/satori/go.uuid v1.2.0 // indirect
 	github.com/sirupsen/logrus v1.6.0
+	github.com/vmihailenco/msgpack/v5 v5.3.5
 	golang.org/x/sys v0.0.0-20200511232937-7e40ca221e25 // indirect
 )
@@ -0,0 +1,318 @@
+package middlewares
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/json-iterator/go"
+)
+
+var (
+	ErrParameterMissing = errors.New("parameter is missing")
+	ErrParameterType    = errors.New("parameter has an unexpected type")
+)
+
+func requireParameter(r *http.Request, k string) (interface{}, error) {
+	if v := Parameter(r, k); v != nil {
+		return v, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrParameterMissing, k)
+}
+
+func typeError(k string, v interface{}, expected string) error {
+	return fmt.Errorf("%w: %s is %T, expecting %s", ErrParameterType, k, v, expected)
+}
+
+// ParameterString returns the parameter as a string. Numbers and booleans
+// are formatted.
+func ParameterString(r *http.Request, k string) (string, error) {
+	v, err := requireParameter(r, k)
+	if err != nil {
+		return "", err
+	}
+	switch value := v.(type) {
+	case string:
+		return value, nil
+	case []string:
+		if len(value) > 0 {
+			return value[0], nil
+		}
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case bool, int, int64, int32, uint, uint64, uint32, jsoniter.Number:
+		return fmt.Sprint(value), nil
+	}
+	return "", typeError(k, v, "string")
+}
+
+// ParameterInt returns the parameter as an int. Strings are parsed and
+// float64 values decoded from JSON are accepted when they are whole numbers.
+func ParameterInt(r *http.Request, k string) (int, error) {
+	v, err := requireParameter(r, k)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := asInt(v)
+	if !ok {
+		return 0, typeError(k, v, "int")
+	}
+	return n, nil
+}
+
+// ParameterFloat64 returns the parameter as a float64, parsing strings
+func ParameterFloat64(r *http.Request, k string) (float64, error) {
+	v, err := requireParameter(r, k)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := toFloat(v)
+	if !ok {
+		return 0, typeError(k, v, "float64")
+	}
+	return n, nil
+}
+
+// ParameterFloat64WithDefault returns the parameter as a float64 or d
+func ParameterFloat64WithDefault(r *http.Request, k string, d float64) float64 {
+	if n, err := ParameterFloat64(r, k); err == nil {
+		return n
+	}
+	return d
+}
+
+// ParameterBool returns the parameter as a bool, parsing strings with
+// strconv.ParseBool
+func ParameterBool(r *http.Request, k string) (bool, error) {
+	v, err := requireParameter(r, k)
+	if err != nil {
+		return false, err
+	}
+	switch value := v.(type) {
+	case bool:
+		return value, nil
+	case string:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b, nil
+		}
+	}
+	return false, typeError(k, v, "bool")
+}
+
+// ParameterBoolWithDefault returns the parameter as a bool or d
+func ParameterBoolWithDefault(r *http.Request, k string, d bool) bool {
+	if b, err := ParameterBool(r, k); err == nil {
+		return b
+	}
+	return d
+}
+
+// ParameterTime returns the parameter parsed with layout
+func ParameterTime(r *http.Request, k string, layout string) (time.Time, error) {
+	v, err := requireParameter(r, k)
+	if err != nil {
+		return time.Time{}, err
+	}
+	switch value := v.(type) {
+	case time.Time:
+		return value, nil
+	case string:
+		t, err := time.Parse(layout, value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w: %s: %v", ErrParameterType, k, err)
+		}
+		return t, nil
+	}
+	return time.Time{}, typeError(k, v, "time")
+}
+
+// ParameterTimeWithDefault returns the parameter parsed with layout or d
+func ParameterTimeWithDefault(r *http.Request, k string, layout string, d time.Time) time.Time {
+	if t, err := ParameterTime(r, k, layout); err == nil {
+		return t
+	}
+	return d
+}
+
+// ParameterDuration returns the parameter parsed with time.ParseDuration
+func ParameterDuration(r *http.Request, k string) (time.Duration, error) {
+	v, err := requireParameter(r, k)
+	if err != nil {
+		return 0, err
+	}
+	switch value := v.(type) {
+	case time.Duration:
+		return value, nil
+	case string:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s: %v", ErrParameterType, k, err)
+		}
+		return d, nil
+	}
+	return 0, typeError(k, v, "duration")
+}
+
+// ParameterDurationWithDefault returns the parameter as a duration or d
+func ParameterDurationWithDefault(r *http.Request, k string, d time.Duration) time.Duration {
+	if duration, err := ParameterDuration(r, k); err == nil {
+		return duration
+	}
+	return d
+}
+
+// ParameterStringSlice returns a list parameter. JSON arrays must hold
+// strings, and a single string is split on commas.
+func ParameterStringSlice(r *http.Request, k string) ([]string, error) {
+	v, err := requireParameter(r, k)
+	if err != nil {
+		return nil, err
+	}
+	switch value := v.(type) {
+	case []string:
+		return value, nil
+	case string:
+		return strings.Split(value, ","), nil
+	case []interface{}:
+		result := make([]string, 0, len(value))
+		for _, item := range value {
+			s, ok := item.(string)
+			if !ok {
+				return nil, typeError(k, v, "[]string")
+			}
+			result = append(result, s)
+		}
+		return result, nil
+	}
+	return nil, typeError(k, v, "[]string")
+}
+
+// ParameterStringSliceWithDefault returns a list parameter or d
+func ParameterStringSliceWithDefault(r *http.Request, k string, d []string) []string {
+	if s, err := ParameterStringSlice(r, k); err == nil {
+		return s
+	}
+	return d
+}
+
+// ParameterIntSlice returns a list parameter converting every item to int
+func ParameterIntSlice(r *http.Request, k string) ([]int, error) {
+	v, err := requireParameter(r, k)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []interface{}
+	switch value := v.(type) {
+	case []int:
+		return value, nil
+	case []interface{}:
+		items = value
+	case []string, string:
+		strs, err := ParameterStringSlice(r, k)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range strs {
+			items = append(items, s)
+		}
+	default:
+		return nil, typeError(k, v, "[]int")
+	}
+
+	result := make([]int, 0, len(items))
+	for _, item := range items {
+		n, ok := asInt(item)
+		if !ok {
+			return nil, typeError(k, v, "[]int")
+		}
+		result = append(result, n)
+	}
+	return result, nil
+}
+
+// ParameterIntSliceWithDefault returns a list parameter as ints or d
+func ParameterIntSliceWithDefault(r *http.Request, k string, d []int) []int {
+	if s, err := ParameterIntSlice(r, k); err == nil {
+		return s
+	}
+	return d
+}
+
+// ParameterMap returns a JSON object parameter
+func ParameterMap(r *http.Request, k string) (map[string]interface{}, error) {
+	v, err := requireParameter(r, k)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := v.(map[string]interface{}); ok {
+		return m, nil
+	}
+	return nil, typeError(k, v, "object")
+}
+
+// ParameterMapWithDefault returns a JSON object parameter or d
+func ParameterMapWithDefault(r *http.Request, k string, d map[string]interface{}) map[string]interface{} {
+	if m, err := ParameterMap(r, k); err == nil {
+		return m
+	}
+	return d
+}
+
+// ParameterPath returns a value nested in a JSON parameter. The first segment
+// of path names the parameter, the others walk objects by key and arrays by
+// index, e.g. "user.addresses.0.zip".
+func ParameterPath(r *http.Request, path string) (interface{}, error) {
+	if v := Parameter(r, path); v != nil {
+		return v, nil
+	}
+
+	parts := strings.SplitN(path, ".", 2)
+	v, err := requireParameter(r, parts[0])
+	if err != nil || len(parts) == 1 {
+		return v, err
+	}
+	if value, ok := lookupPath(v, parts[1]); ok {
+		return value, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrParameterMissing, path)
+}
+
+// ParameterPathWithDefault returns a value nested in a JSON parameter or d
+func ParameterPathWithDefault(r *http.Request, path string, d interface{}) interface{} {
+	if v, err := ParameterPath(r, path); err == nil {
+		return v
+	}
+	return d
+}
+
+// asInt converts integers, whole floats and numeric strings to int
+func asInt(v interface{}) (int, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() > math.MaxInt64 {
+			return 0, false
+		}
+		return int(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		// float64(math.MaxInt64) rounds up to 2^63, so compare with the
+		// exact powers of two
+		f := rv.Float()
+		if f != math.Trunc(f) || f >= 1<<63 || f < -1<<63 {
+			return 0, false
+		}
+		return int(f), true
+	case reflect.String:
+		n, err := strconv.Atoi(rv.String())
+		return n, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,176 @@
+package middlewares
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parameterRequest returns a request whose store holds values as body
+// parameters
+func parameterRequest(values map[string]interface{}) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	params := make(parameterStore, len(values))
+	for k, v := range values {
+		params[k] = storedParameter{Value: v, Source: SourceJSON}
+	}
+	return r.WithContext(withParameters(r, params))
+}
+
+func TestAsInt(t *testing.T) {
+	cases := []struct {
+		value interface{}
+		n     int
+		ok    bool
+	}{
+		{float64(42), 42, true},
+		{float64(-42), -42, true},
+		{1.5, 0, false},
+		{float64(-1 << 63), math.MinInt64, true},
+		// float64(math.MaxInt64) is 2^63, which does not fit
+		{float64(math.MaxInt64), 0, false},
+		{math.Inf(1), 0, false},
+		{math.NaN(), 0, false},
+		{uint64(math.MaxUint64), 0, false},
+		{int32(7), 7, true},
+		{"12", 12, true},
+		{"12.0", 0, false},
+		{true, 0, false},
+	}
+	for _, c := range cases {
+		if n, ok := asInt(c.value); n != c.n || ok != c.ok {
+			t.Errorf("asInt(%#v) = %d, %v, want %d, %v", c.value, n, ok, c.n, c.ok)
+		}
+	}
+}
+
+func TestParameterAccessors(t *testing.T) {
+	r := parameterRequest(map[string]interface{}{
+		"count":    float64(3),
+		"ratio":    "0.25",
+		"price":    float64(9.5),
+		"enabled":  "true",
+		"verified": true,
+		"since":    "2020-06-01",
+		"ttl":      "1m30s",
+		"tags":     "a,b",
+		"list":     []interface{}{"x", "y"},
+		"ids":      []interface{}{float64(1), "2"},
+		"user":     map[string]interface{}{"addresses": []interface{}{map[string]interface{}{"zip": "75001"}}},
+		"bad":      "nope",
+		"fraction": 1.5,
+		"mixed":    []interface{}{"x", float64(1)},
+	})
+
+	check := func(name string, got, want interface{}, err error) {
+		t.Helper()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %#v, want %#v", name, got, want)
+		}
+	}
+	n, err := ParameterInt(r, "count")
+	check("ParameterInt", n, 3, err)
+	f, err := ParameterFloat64(r, "ratio")
+	check("ParameterFloat64 string", f, 0.25, err)
+	f, err = ParameterFloat64(r, "price")
+	check("ParameterFloat64", f, 9.5, err)
+	b, err := ParameterBool(r, "enabled")
+	check("ParameterBool string", b, true, err)
+	b, err = ParameterBool(r, "verified")
+	check("ParameterBool", b, true, err)
+	since, err := ParameterTime(r, "since", "2006-01-02")
+	check("ParameterTime", since, time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC), err)
+	d, err := ParameterDuration(r, "ttl")
+	check("ParameterDuration", d, 90*time.Second, err)
+	strs, err := ParameterStringSlice(r, "tags")
+	check("ParameterStringSlice comma", strs, []string{"a", "b"}, err)
+	strs, err = ParameterStringSlice(r, "list")
+	check("ParameterStringSlice", strs, []string{"x", "y"}, err)
+	ints, err := ParameterIntSlice(r, "ids")
+	check("ParameterIntSlice", ints, []int{1, 2}, err)
+	m, err := ParameterMap(r, "user")
+	check("ParameterMap", len(m), 1, err)
+	zip, err := ParameterPath(r, "user.addresses.0.zip")
+	check("ParameterPath", zip, "75001", err)
+
+	typeErrors := map[string]func() error{
+		"ParameterInt fraction":   func() error { _, err := ParameterInt(r, "fraction"); return err },
+		"ParameterInt string":     func() error { _, err := ParameterInt(r, "bad"); return err },
+		"ParameterFloat64":        func() error { _, err := ParameterFloat64(r, "bad"); return err },
+		"ParameterBool":           func() error { _, err := ParameterBool(r, "bad"); return err },
+		"ParameterTime":           func() error { _, err := ParameterTime(r, "bad", time.RFC3339); return err },
+		"ParameterDuration":       func() error { _, err := ParameterDuration(r, "bad"); return err },
+		"ParameterStringSlice":    func() error { _, err := ParameterStringSlice(r, "mixed"); return err },
+		"ParameterIntSlice":       func() error { _, err := ParameterIntSlice(r, "tags"); return err },
+		"ParameterMap":            func() error { _, err := ParameterMap(r, "list"); return err },
+		"ParameterTime of a bool": func() error { _, err := ParameterTime(r, "verified", time.RFC3339); return err },
+	}
+	for name, call := range typeErrors {
+		if err := call(); !errors.Is(err, ErrParameterType) {
+			t.Errorf("%s: %v, want ErrParameterType", name, err)
+		}
+	}
+
+	missing := map[string]func() error{
+		"ParameterInt":         func() error { _, err := ParameterInt(r, "missing"); return err },
+		"ParameterFloat64":     func() error { _, err := ParameterFloat64(r, "missing"); return err },
+		"ParameterBool":        func() error { _, err := ParameterBool(r, "missing"); return err },
+		"ParameterTime":        func() error { _, err := ParameterTime(r, "missing", time.RFC3339); return err },
+		"ParameterDuration":    func() error { _, err := ParameterDuration(r, "missing"); return err },
+		"ParameterStringSlice": func() error { _, err := ParameterStringSlice(r, "missing"); return err },
+		"ParameterIntSlice":    func() error { _, err := ParameterIntSlice(r, "missing"); return err },
+		"ParameterMap":         func() error { _, err := ParameterMap(r, "missing"); return err },
+		"ParameterPath":        func() error { _, err := ParameterPath(r, "user.addresses.1.zip"); return err },
+	}
+	for name, call := range missing {
+		if err := call(); !errors.Is(err, ErrParameterMissing) {
+			t.Errorf("%s: %v, want ErrParameterMissing", name, err)
+		}
+	}
+}
+
+func TestParameterDefaults(t *testing.T) {
+	r := parameterRequest(map[string]interface{}{"bad": "nope", "count": float64(2)})
+	since := time.Unix(0, 0)
+	cases := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"int", ParameterIntWithDefault(r, "bad", 7), 7},
+		{"int present", ParameterIntWithDefault(r, "count", 7), 2},
+		{"float64", ParameterFloat64WithDefault(r, "missing", 1.5), 1.5},
+		{"bool", ParameterBoolWithDefault(r, "bad", true), true},
+		{"time", ParameterTimeWithDefault(r, "bad", time.RFC3339, since), since},
+		{"duration", ParameterDurationWithDefault(r, "missing", time.Second), time.Second},
+		{"string slice", ParameterStringSliceWithDefault(r, "missing", []string{"a"}), []string{"a"}},
+		{"int slice", ParameterIntSliceWithDefault(r, "bad", []int{1}), []int{1}},
+		{"map", ParameterMapWithDefault(r, "bad", map[string]interface{}{}), map[string]interface{}{}},
+		{"path", ParameterPathWithDefault(r, "bad.x", "d"), "d"},
+	}
+	for _, c := range cases {
+		if !reflect.DeepEqual(c.got, c.want) {
+			t.Errorf("%s: %#v, want %#v", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestParameterIntFromJSONBody(t *testing.T) {
+	var n int
+	var err error
+	handler := RequireParametersInBody("count")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err = ParameterInt(r, "count")
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"count": 9007199254740992}`))
+	r.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if err != nil || n != 1<<53 {
+		t.Errorf("ParameterInt = %d, %v", n, err)
+	}
+}
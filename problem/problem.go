@@ -0,0 +1,129 @@
+/*
+Package problem renders errors raised by the middlewares. By default errors
+are written as RFC 7807 application/problem+json documents; a router can pick
+another ErrorResponder with WithResponder.
+*/
+package problem
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/json-iterator/go"
+)
+
+const (
+	responderCtxKey = "problem.responder"
+
+	// ContentType is the media type of the documents written by ProblemJSON
+	ContentType = "application/problem+json"
+)
+
+var (
+	json = jsoniter.ConfigCompatibleWithStandardLibrary
+
+	// Default is used when no responder is configured for the request
+	Default ErrorResponder = ProblemJSON{}
+)
+
+// ErrorResponder writes the response for a request rejected by a middleware
+type ErrorResponder interface {
+	RespondError(w http.ResponseWriter, r *http.Request, status int, err error)
+}
+
+// ErrorResponderFunc adapts a function to ErrorResponder
+type ErrorResponderFunc func(w http.ResponseWriter, r *http.Request, status int, err error)
+
+// RespondError calls f(w, r, status, err)
+func (f ErrorResponderFunc) RespondError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	f(w, r, status, err)
+}
+
+// InvalidParam is a single entry of the invalid-params extension member
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// InvalidParamsError is implemented by errors that can list failing parameters
+type InvalidParamsError interface {
+	error
+	InvalidParams() []InvalidParam
+}
+
+// Details is an RFC 7807 problem details document
+type Details struct {
+	Type          string         `json:"type"`
+	Title         string         `json:"title"`
+	Status        int            `json:"status"`
+	Detail        string         `json:"detail,omitempty"`
+	Instance      string         `json:"instance,omitempty"`
+	InvalidParams []InvalidParam `json:"invalid-params,omitempty"`
+}
+
+// NewDetails builds the problem document describing err. Details of server
+// errors are left out so internals don't leak to clients.
+func NewDetails(r *http.Request, status int, err error) Details {
+	details := Details{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Instance: r.URL.Path,
+	}
+	if err != nil && status < http.StatusInternalServerError {
+		details.Detail = err.Error()
+		var paramsErr InvalidParamsError
+		if errors.As(err, &paramsErr) {
+			details.InvalidParams = paramsErr.InvalidParams()
+		}
+	}
+	return details
+}
+
+// ProblemJSON writes errors as application/problem+json
+type ProblemJSON struct{}
+
+// RespondError implements ErrorResponder
+func (ProblemJSON) RespondError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	body, marshalErr := json.Marshal(NewDetails(r, status, err))
+	if marshalErr != nil {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// StatusOnly writes the status code with an empty body
+type StatusOnly struct{}
+
+// RespondError implements ErrorResponder
+func (StatusOnly) RespondError(w http.ResponseWriter, _ *http.Request, status int, _ error) {
+	w.WriteHeader(status)
+}
+
+// WithResponder makes every middleware below it render errors with responder
+func WithResponder(responder ErrorResponder) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), responderCtxKey, responder)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// GetResponder returns the responder configured for the request
+func GetResponder(r *http.Request) ErrorResponder {
+	if responder, ok := r.Context().Value(responderCtxKey).(ErrorResponder); ok {
+		return responder
+	}
+	return Default
+}
+
+// Respond renders err with the responder configured for the request
+func Respond(w http.ResponseWriter, r *http.Request, status int, err error) {
+	GetResponder(r).RespondError(w, r, status, err)
+}
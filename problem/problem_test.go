@@ -0,0 +1,102 @@
+package problem
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type paramsError []InvalidParam
+
+func (e paramsError) Error() string { return "invalid parameters" }
+
+func (e paramsError) InvalidParams() []InvalidParam { return e }
+
+func TestProblemJSON(t *testing.T) {
+	invalid := paramsError{{Name: "age", Reason: "must be positive"}}
+	cases := []struct {
+		name    string
+		status  int
+		err     error
+		details Details
+	}{
+		{"client error", http.StatusBadRequest, errors.New("bad input"), Details{
+			Type: "about:blank", Title: "Bad Request", Status: http.StatusBadRequest,
+			Detail: "bad input", Instance: "/users",
+		}},
+		{"wrapped invalid params", http.StatusUnprocessableEntity, fmt.Errorf("binding: %w", invalid), Details{
+			Type: "about:blank", Title: "Unprocessable Entity", Status: http.StatusUnprocessableEntity,
+			Detail: "binding: invalid parameters", Instance: "/users", InvalidParams: invalid,
+		}},
+		{"server error", http.StatusInternalServerError, errors.New("db password is hunter2"), Details{
+			Type: "about:blank", Title: "Internal Server Error", Status: http.StatusInternalServerError,
+			Instance: "/users",
+		}},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/users", nil)
+		ProblemJSON{}.RespondError(w, r, c.status, c.err)
+
+		if w.Code != c.status {
+			t.Errorf("%s: status %d, want %d", c.name, w.Code, c.status)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != ContentType {
+			t.Errorf("%s: Content-Type %q", c.name, ct)
+		}
+		if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s: missing X-Content-Type-Options", c.name)
+		}
+		var details Details
+		if err := json.Unmarshal(w.Body.Bytes(), &details); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if !reflect.DeepEqual(details, c.details) {
+			t.Errorf("%s: %+v, want %+v", c.name, details, c.details)
+		}
+	}
+}
+
+func TestStatusOnly(t *testing.T) {
+	w := httptest.NewRecorder()
+	StatusOnly{}.RespondError(w, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusForbidden, errors.New("denied"))
+	if w.Code != http.StatusForbidden || w.Body.Len() != 0 || w.Header().Get("Content-Type") != "" {
+		t.Errorf("got %d %q %q", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+}
+
+func TestWithResponder(t *testing.T) {
+	reject := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Respond(w, r, http.StatusTeapot, errors.New("no coffee"))
+	})
+
+	w := httptest.NewRecorder()
+	reject.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Header().Get("Content-Type") != ContentType {
+		t.Errorf("default responder wrote %q", w.Header().Get("Content-Type"))
+	}
+
+	var seen ErrorResponder
+	custom := ErrorResponderFunc(func(w http.ResponseWriter, r *http.Request, status int, err error) {
+		seen = GetResponder(r)
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(err.Error()))
+	})
+	w = httptest.NewRecorder()
+	WithResponder(custom)(reject).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusTeapot || w.Body.String() != "no coffee" {
+		t.Errorf("got %d %q", w.Code, w.Body.String())
+	}
+	if seen == nil {
+		t.Error("GetResponder did not return the router responder")
+	}
+
+	w = httptest.NewRecorder()
+	WithResponder(StatusOnly{})(reject).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusTeapot || w.Body.Len() != 0 {
+		t.Errorf("StatusOnly router wrote %d %q", w.Code, w.Body.String())
+	}
+}
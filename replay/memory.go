@@ -0,0 +1,83 @@
+package replay
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	nonce   string
+	expires time.Time
+}
+
+// MemoryStore keeps nonces in process memory, evicting expired nonces first
+// and then the least recently seen ones when full. Capacity should cover the
+// number of requests expected during twice the guard window, an evicted
+// nonce can be replayed.
+type MemoryStore struct {
+	capacity int
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // front is the most recently seen
+}
+
+// NewMemoryStore returns a store holding up to capacity nonces, 0 means
+// unbounded
+func NewMemoryStore(capacity int) *MemoryStore {
+	return &MemoryStore{
+		capacity: capacity,
+		now:      time.Now,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Seen implements NonceStore
+func (store *MemoryStore) Seen(nonce string, ttl time.Duration) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := store.now()
+	if element, ok := store.entries[nonce]; ok {
+		entry := element.Value.(*memoryEntry)
+		if now.Before(entry.expires) {
+			store.order.MoveToFront(element)
+			return true, nil
+		}
+		store.remove(element)
+	}
+
+	store.evict(now)
+	store.entries[nonce] = store.order.PushFront(&memoryEntry{nonce: nonce, expires: now.Add(ttl)})
+	return false, nil
+}
+
+// evict makes room for one more entry when the store is full, dropping the
+// expired entries and then the least recently seen ones
+func (store *MemoryStore) evict(now time.Time) {
+	if store.capacity <= 0 || store.order.Len() < store.capacity {
+		return
+	}
+	for element := store.order.Back(); element != nil; {
+		previous := element.Prev()
+		if !now.Before(element.Value.(*memoryEntry).expires) {
+			store.remove(element)
+		}
+		element = previous
+	}
+	for store.order.Len() >= store.capacity {
+		store.remove(store.order.Back())
+	}
+}
+
+func (store *MemoryStore) remove(element *list.Element) {
+	store.order.Remove(element)
+	delete(store.entries, element.Value.(*memoryEntry).nonce)
+}
+
+var (
+	_ NonceStore = (*MemoryStore)(nil)
+)
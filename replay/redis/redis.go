@@ -0,0 +1,41 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/jeffguorg/middlewares/replay"
+)
+
+// Client remembers nonces in redis
+type Client struct {
+	rclient *redis.Client
+	keyFmt  string
+}
+
+// New return a new Client instance
+func New(keyFmt string, options *redis.Options) Client {
+	return NewWithClient(keyFmt, redis.NewClient(options))
+}
+
+// NewWithClient return a Client sharing an existing redis connection
+func NewWithClient(keyFmt string, redisClient *redis.Client) Client {
+	return Client{rclient: redisClient, keyFmt: keyFmt}
+}
+
+// Seen records nonce with SETNX so concurrent requests cannot both succeed
+func (client Client) Seen(nonce string, ttl time.Duration) (bool, error) {
+	if ttl < time.Millisecond {
+		ttl = time.Millisecond
+	}
+	set, err := client.rclient.SetNX(fmt.Sprintf(client.keyFmt, nonce), 1, ttl).Result()
+	if err != nil {
+		return false, err
+	}
+	return !set, nil
+}
+
+var (
+	_ replay.NonceStore = Client{}
+)
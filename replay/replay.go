@@ -0,0 +1,151 @@
+/*
+Package replay rejects replayed requests. A Guard accepts a request only when
+its timestamp is within a window around the server time and its nonce has not
+been seen during that window.
+
+The guard does not authenticate anything by itself: put it after signature
+verification and make sure the timestamp and nonce are covered by the
+signature, otherwise an attacker simply picks fresh values.
+*/
+package replay
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+const (
+	DefaultWindow          = 5 * time.Minute
+	DefaultTimestampHeader = "X-Timestamp"
+	DefaultNonceHeader     = "X-Nonce"
+)
+
+var (
+	ErrTimestampMissing = errors.New("request timestamp is missing")
+	ErrTimestampInvalid = errors.New("request timestamp is invalid")
+	ErrTimestampWindow  = errors.New("request timestamp is outside of the accepted window")
+	ErrNonceMissing     = errors.New("request nonce is missing")
+	ErrNonceReused      = errors.New("request nonce has already been used")
+)
+
+// StoreError reports a NonceStore failure, as opposed to a stale or
+// replayed request
+type StoreError struct {
+	Err error
+}
+
+func (e *StoreError) Error() string {
+	return "nonce store failed: " + e.Err.Error()
+}
+
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+// NonceStore remembers nonces
+type NonceStore interface {
+	// Seen records nonce for ttl and reports whether it was already
+	// recorded. Implementations must check and record atomically.
+	Seen(nonce string, ttl time.Duration) (bool, error)
+}
+
+// Guard checks the freshness of requests
+type Guard struct {
+	// Store remembers the nonces, only timestamps are checked when nil
+	Store NonceStore
+
+	// Window is the accepted distance between the request timestamp and the
+	// server time, in both directions. DefaultWindow when 0.
+	Window time.Duration
+
+	// TimestampHeader and NonceHeader name the headers carrying the values,
+	// DefaultTimestampHeader and DefaultNonceHeader when empty. Timestamps
+	// are unix seconds or RFC 3339.
+	TimestampHeader string
+	NonceHeader     string
+
+	// Extract reads the timestamp and nonce from the request instead of the
+	// headers when set, e.g. from the created and nonce parameters of a
+	// message signature
+	Extract func(r *http.Request) (time.Time, string, error)
+
+	// Now returns the current time, time.Now when nil
+	Now func() time.Time
+}
+
+// Check returns nil if r is fresh and records its nonce. Store failures are
+// returned as *StoreError.
+func (guard Guard) Check(r *http.Request) error {
+	timestamp, nonce, err := guard.extract(r)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if guard.Now != nil {
+		now = guard.Now()
+	}
+	window := guard.Window
+	if window == 0 {
+		window = DefaultWindow
+	}
+	if timestamp.Before(now.Add(-window)) || timestamp.After(now.Add(window)) {
+		return ErrTimestampWindow
+	}
+
+	if guard.Store == nil {
+		return nil
+	}
+	if nonce == "" {
+		return ErrNonceMissing
+	}
+	// the nonce is kept until its timestamp leaves the window, after that
+	// the timestamp check rejects the request anyway
+	seen, err := guard.Store.Seen(nonce, timestamp.Add(window).Sub(now))
+	if err != nil {
+		return &StoreError{Err: err}
+	}
+	if seen {
+		return ErrNonceReused
+	}
+	return nil
+}
+
+func (guard Guard) extract(r *http.Request) (time.Time, string, error) {
+	if guard.Extract != nil {
+		return guard.Extract(r)
+	}
+
+	timestampHeader, nonceHeader := guard.TimestampHeader, guard.NonceHeader
+	if timestampHeader == "" {
+		timestampHeader = DefaultTimestampHeader
+	}
+	if nonceHeader == "" {
+		nonceHeader = DefaultNonceHeader
+	}
+
+	value := r.Header.Get(timestampHeader)
+	if value == "" {
+		return time.Time{}, "", ErrTimestampMissing
+	}
+	timestamp, err := ParseTimestamp(value)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	return timestamp, r.Header.Get(nonceHeader), nil
+}
+
+// ParseTimestamp parses unix seconds or an RFC 3339 time
+func ParseTimestamp(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	timestamp, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, ErrTimestampInvalid
+	}
+	return timestamp, nil
+}
@@ -0,0 +1,92 @@
+package replay
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type failingStore struct{}
+
+func (failingStore) Seen(string, time.Duration) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func replayRequest(timestamp, nonce string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	if timestamp != "" {
+		r.Header.Set(DefaultTimestampHeader, timestamp)
+	}
+	if nonce != "" {
+		r.Header.Set(DefaultNonceHeader, nonce)
+	}
+	return r
+}
+
+func TestGuard(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	guard := Guard{Store: NewMemoryStore(0), Now: func() time.Time { return now }}
+	fresh := strconv.FormatInt(now.Unix(), 10)
+
+	if err := guard.Check(replayRequest(fresh, "a")); err != nil {
+		t.Fatalf("fresh request rejected: %v", err)
+	}
+	if err := guard.Check(replayRequest(now.Format(time.RFC3339), "b")); err != nil {
+		t.Fatalf("RFC 3339 timestamp rejected: %v", err)
+	}
+
+	cases := map[string]struct {
+		r   *http.Request
+		err error
+	}{
+		"replayed":          {replayRequest(fresh, "a"), ErrNonceReused},
+		"stale":             {replayRequest(strconv.FormatInt(now.Add(-DefaultWindow-time.Second).Unix(), 10), "c"), ErrTimestampWindow},
+		"future":            {replayRequest(strconv.FormatInt(now.Add(DefaultWindow+time.Second).Unix(), 10), "d"), ErrTimestampWindow},
+		"missing timestamp": {replayRequest("", "e"), ErrTimestampMissing},
+		"invalid timestamp": {replayRequest("yesterday", "f"), ErrTimestampInvalid},
+		"missing nonce":     {replayRequest(fresh, ""), ErrNonceMissing},
+	}
+	for name, c := range cases {
+		if err := guard.Check(c.r); err != c.err {
+			t.Errorf("%s: %v, want %v", name, err, c.err)
+		}
+	}
+}
+
+func TestGuardStoreError(t *testing.T) {
+	guard := Guard{Store: failingStore{}}
+	err := guard.Check(replayRequest(strconv.FormatInt(time.Now().Unix(), 10), "a"))
+	var storeErr *StoreError
+	if !errors.As(err, &storeErr) {
+		t.Fatalf("%v is not a StoreError", err)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	store := NewMemoryStore(2)
+	store.now = func() time.Time { return now }
+
+	if seen, _ := store.Seen("a", time.Minute); seen {
+		t.Fatal("new nonce seen")
+	}
+	if seen, _ := store.Seen("a", time.Minute); !seen {
+		t.Fatal("nonce not remembered")
+	}
+
+	// a full store evicts the least recently seen nonce
+	_, _ = store.Seen("b", time.Minute)
+	_, _ = store.Seen("a", time.Minute)
+	_, _ = store.Seen("c", time.Minute)
+	if seen, _ := store.Seen("a", time.Minute); !seen {
+		t.Error("recently seen nonce evicted")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if seen, _ := store.Seen("a", time.Minute); seen {
+		t.Error("expired nonce still seen")
+	}
+}
@@ -0,0 +1,221 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jeffguorg/middlewares/problem"
+	"github.com/jeffguorg/middlewares/signature"
+)
+
+const defaultResponseSignatureHeader = "X-Signature"
+
+type responseSignatureConfig struct {
+	header  string
+	headers []string
+	trailer bool
+}
+
+// ResponseSignatureOption configures SignResponse and VerifyResponse
+type ResponseSignatureOption func(*responseSignatureConfig)
+
+// ResponseSignatureHeader sets the header carrying the signature, X-Signature
+// by default
+func ResponseSignatureHeader(name string) ResponseSignatureOption {
+	return func(c *responseSignatureConfig) {
+		c.header = name
+	}
+}
+
+// SignResponseHeaders selects the response headers covered by the signature,
+// Content-Type by default
+func SignResponseHeaders(names ...string) ResponseSignatureOption {
+	return func(c *responseSignatureConfig) {
+		c.headers = names
+	}
+}
+
+// SignInTrailer streams the response instead of buffering it and sends the
+// signature as a trailer. Content-Length is dropped so the response is
+// chunked, and the covered headers are the ones set when the handler writes
+// the first body bytes.
+func SignInTrailer() ResponseSignatureOption {
+	return func(c *responseSignatureConfig) {
+		c.trailer = true
+	}
+}
+
+func newResponseSignatureConfig(options []ResponseSignatureOption) responseSignatureConfig {
+	config := responseSignatureConfig{header: defaultResponseSignatureHeader, headers: []string{"Content-Type"}}
+	for _, option := range options {
+		option(&config)
+	}
+	return config
+}
+
+// responseSigningString is the status code, one "name: value" line per
+// covered header and the base64 SHA-256 of the body, separated by newlines
+func responseSigningString(status int, header http.Header, names []string, bodyHash []byte) string {
+	var b strings.Builder
+	b.WriteString(strconv.Itoa(status))
+	b.WriteByte('\n')
+	writeSignedHeaders(&b, header, names)
+	b.WriteString(base64.StdEncoding.EncodeToString(bodyHash))
+	return b.String()
+}
+
+func writeSignedHeaders(b *strings.Builder, header http.Header, names []string) {
+	for _, name := range names {
+		b.WriteString(strings.ToLower(name))
+		b.WriteString(": ")
+		b.WriteString(strings.Join(header.Values(name), ", "))
+		b.WriteByte('\n')
+	}
+}
+
+// SignResponse signs responses with signingMethod. The response is buffered
+// and the signature sent in a header, unless SignInTrailer is given.
+func SignResponse(signingMethod signature.SigningMethod, options ...ResponseSignatureOption) func(handler http.Handler) http.Handler {
+	config := newResponseSignatureConfig(options)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if config.trailer {
+				streaming := &signingResponse{ResponseWriter: w, config: config, method: signingMethod, hash: sha256.New()}
+				next.ServeHTTP(streaming, r)
+				streaming.sign()
+				return
+			}
+
+			buffered := &bufferedResponse{ResponseWriter: w}
+			next.ServeHTTP(buffered, r)
+			buffered.WriteHeader(http.StatusOK)
+
+			sniffContentType(w.Header(), buffered.status, buffered.body.Bytes())
+			bodyHash := sha256.Sum256(buffered.body.Bytes())
+			sign, err := signingMethod.Sign(responseSigningString(buffered.status, w.Header(), config.headers, bodyHash[:]))
+			if err != nil {
+				problem.Respond(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			w.Header().Set(config.header, sign)
+			buffered.flush()
+		})
+	}
+}
+
+// sniffContentType sets the Content-Type net/http would detect from the first
+// body bytes, so it is in place before the headers are signed
+func sniffContentType(header http.Header, status int, p []byte) {
+	if _, ok := header["Content-Type"]; ok || len(p) == 0 || !bodyAllowed(status) {
+		return
+	}
+	if header.Get("Transfer-Encoding") != "" {
+		return
+	}
+	header.Set("Content-Type", http.DetectContentType(p))
+}
+
+// signingResponse hashes the body while it is streamed and signs it in a
+// trailer
+type signingResponse struct {
+	http.ResponseWriter
+	config      responseSignatureConfig
+	method      signature.SigningMethod
+	hash        hash.Hash
+	status      int
+	wroteHeader bool
+	headers     string
+}
+
+// WriteHeader only records status, the headers are sent with the first body
+// bytes so the Content-Type can be sniffed before they are signed
+func (w *signingResponse) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *signingResponse) writeHeader(p []byte) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.WriteHeader(http.StatusOK)
+	sniffContentType(w.Header(), w.status, p)
+	var b strings.Builder
+	writeSignedHeaders(&b, w.Header(), w.config.headers)
+	w.headers = b.String()
+
+	if !bodyAllowed(w.status) {
+		// no body means no trailer either, the empty body is signed now
+		w.setSignature()
+	} else {
+		w.Header().Add("Trailer", w.config.header)
+		w.Header().Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+func (w *signingResponse) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	w.writeHeader(p)
+	w.hash.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush lets streaming handlers push what they wrote so far
+func (w *signingResponse) Flush() {
+	w.writeHeader(nil)
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// sign sets the trailer, a failure leaves the response unsigned since the
+// body is already sent
+func (w *signingResponse) sign() {
+	w.writeHeader(nil)
+	if bodyAllowed(w.status) {
+		w.setSignature()
+	}
+}
+
+func (w *signingResponse) setSignature() {
+	signingString := strconv.Itoa(w.status) + "\n" + w.headers + base64.StdEncoding.EncodeToString(w.hash.Sum(nil))
+	if sign, err := w.method.Sign(signingString); err == nil {
+		w.Header().Set(w.config.header, sign)
+	}
+}
+
+// VerifyResponse checks a response signed by SignResponse with the same
+// options, reading the signature from the header or the trailer. The body is
+// read and replaced so the caller can still consume it.
+func VerifyResponse(resp *http.Response, signingMethod signature.SigningMethod, options ...ResponseSignatureOption) error {
+	config := newResponseSignatureConfig(options)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	sign := resp.Header.Get(config.header)
+	if sign == "" {
+		sign = resp.Trailer.Get(config.header)
+	}
+	if sign == "" {
+		return signature.ErrSignatureInvalid
+	}
+	bodyHash := sha256.Sum256(body)
+	return signingMethod.Verify(responseSigningString(resp.StatusCode, resp.Header, config.headers, bodyHash[:]), sign)
+}
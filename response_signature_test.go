@@ -0,0 +1,77 @@
+package middlewares
+
+import (
+	"crypto"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jeffguorg/middlewares/signature"
+)
+
+func TestSignResponseRoundTrip(t *testing.T) {
+	method := signature.SigningMethodHMAC{Key: []byte("secret"), HashMethod: crypto.SHA256}
+	handlers := map[string]http.HandlerFunc{
+		// Content-Type is left to net/http to sniff
+		"sniffed": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("<html><body>hello</body></html>"))
+		},
+		"explicit": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"id":1}`))
+		},
+		"empty": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		},
+	}
+	modes := map[string][]ResponseSignatureOption{
+		"header":  nil,
+		"trailer": {SignInTrailer()},
+	}
+
+	for mode, options := range modes {
+		for name, handler := range handlers {
+			server := httptest.NewServer(SignResponse(method, options...)(handler))
+			resp, err := http.Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyResponse(resp, method, options...); err != nil {
+				t.Errorf("%s %s: %v", mode, name, err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			// a tampered body must not verify
+			if len(body) > 0 {
+				resp.Body = ioutil.NopCloser(strings.NewReader(""))
+				if err := VerifyResponse(resp, method, options...); err == nil {
+					t.Errorf("%s %s: emptied body verified", mode, name)
+				}
+			}
+			server.Close()
+		}
+	}
+}
+
+func TestVerifyResponseTamperedHeader(t *testing.T) {
+	method := signature.SigningMethodHMAC{Key: []byte("secret"), HashMethod: crypto.SHA256}
+	server := httptest.NewServer(SignResponse(method)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	})))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	resp.Header.Set("Content-Type", "text/html")
+	if err := VerifyResponse(resp, method); err == nil {
+		t.Error("tampered Content-Type verified")
+	}
+}
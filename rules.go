@@ -0,0 +1,429 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jeffguorg/middlewares/problem"
+)
+
+// FieldError describes why a single parameter failed validation
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Reason)
+}
+
+// ValidationError collects every failing parameter of a request
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	reasons := make([]string, 0, len(e))
+	for _, fieldErr := range e {
+		reasons = append(reasons, fieldErr.Error())
+	}
+	return "validation failed: " + strings.Join(reasons, "; ")
+}
+
+// InvalidParams implements problem.InvalidParamsError
+func (e ValidationError) InvalidParams() []problem.InvalidParam {
+	params := make([]problem.InvalidParam, 0, len(e))
+	for _, fieldErr := range e {
+		params = append(params, problem.InvalidParam{Name: fieldErr.Field, Reason: fieldErr.Reason})
+	}
+	return params
+}
+
+// rule checks a present value and returns the reason it is rejected, or ""
+type rule func(v interface{}) string
+
+// parameterSpec is a key passed to the RequireParameters* middlewares.
+//
+// A key may carry rules after a "|", separated by commas:
+//
+//	"name|min=3,max=20"
+//	"age|gte=18,lte=130"
+//	"user.address.zip|pattern=^[0-9]{5}$"
+//	"sort|optional,oneof=asc desc"
+//
+// Keys are required unless "optional" is given. Available rules are min, max
+// and len (string length, slice length or number value), gte and lte (numeric,
+// strings are parsed), int, number and bool (type checks, strings are
+// parsed), oneof (space separated), pattern, email, url and uuid.
+// pattern consumes the rest of the spec so it must come last. On lists, min,
+// max and len count the items and the other rules check every item.
+//
+// Query keys may also declare how lists are sent with array=repeat
+// (?tag=a&tag=b), array=brackets (?ids[]=1&ids[]=2) or array=comma
+// (?ids=1,2,3). Such keys are stored as []string.
+type parameterSpec struct {
+	key      string
+	required bool
+	rules    []rule
+	array    arrayStyle
+}
+
+type arrayStyle int
+
+const (
+	arrayNone arrayStyle = iota
+	arrayRepeat
+	arrayBrackets
+	arrayComma
+)
+
+func mustParseParameterSpecs(keys []string) []parameterSpec {
+	specs := make([]parameterSpec, 0, len(keys))
+	for _, key := range keys {
+		spec := parameterSpec{key: key, required: true}
+		if idx := strings.IndexByte(key, '|'); idx >= 0 {
+			spec.key = key[:idx]
+			if err := spec.parse(key[idx+1:]); err != nil {
+				panic(fmt.Sprintf("middlewares: invalid rules for %q: %v", spec.key, err))
+			}
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// check validates the value found for spec and appends failures to errs
+func (spec parameterSpec) check(value interface{}, present bool, errs ValidationError) ValidationError {
+	if !present {
+		if spec.required {
+			errs = append(errs, FieldError{Field: spec.key, Reason: "is required"})
+		}
+		return errs
+	}
+	for _, r := range spec.rules {
+		if reason := r(value); reason != "" {
+			errs = append(errs, FieldError{Field: spec.key, Reason: reason})
+		}
+	}
+	return errs
+}
+
+// parse reads a comma separated rule list into spec
+func (spec *parameterSpec) parse(rules string) error {
+	for len(rules) > 0 {
+		var item string
+		if strings.HasPrefix(rules, "pattern=") {
+			item, rules = rules, ""
+		} else if idx := strings.IndexByte(rules, ','); idx >= 0 {
+			item, rules = rules[:idx], rules[idx+1:]
+		} else {
+			item, rules = rules, ""
+		}
+
+		name, arg := item, ""
+		if idx := strings.IndexByte(item, '='); idx >= 0 {
+			name, arg = item[:idx], item[idx+1:]
+		}
+
+		var r rule
+		switch name {
+		case "":
+		case "required":
+			spec.required = true
+		case "optional", "omitempty":
+			spec.required = false
+		case "array":
+			switch arg {
+			case "repeat":
+				spec.array = arrayRepeat
+			case "brackets":
+				spec.array = arrayBrackets
+			case "comma":
+				spec.array = arrayComma
+			default:
+				return fmt.Errorf("unknown array style %q", arg)
+			}
+		case "min", "max", "len":
+			n, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return fmt.Errorf("%s requires a number: %v", name, err)
+			}
+			spec.rules = append(spec.rules, boundRule(name, n))
+		case "gte", "lte":
+			n, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return fmt.Errorf("%s requires a number: %v", name, err)
+			}
+			r = boundRule(name, n)
+		case "int":
+			r = func(v interface{}) string {
+				if _, ok := asInt(v); !ok {
+					return "must be an integer"
+				}
+				return ""
+			}
+		case "number":
+			r = func(v interface{}) string {
+				if _, ok := toFloat(v); !ok {
+					return "must be a number"
+				}
+				return ""
+			}
+		case "bool":
+			r = func(v interface{}) string {
+				if _, ok := v.(bool); ok {
+					return ""
+				}
+				if _, err := strconv.ParseBool(fmt.Sprint(v)); err != nil {
+					return "must be a boolean"
+				}
+				return ""
+			}
+		case "oneof":
+			r = oneOfRule(strings.Fields(arg))
+		case "pattern":
+			re, err := regexp.Compile(arg)
+			if err != nil {
+				return err
+			}
+			r = func(v interface{}) string {
+				if !re.MatchString(fmt.Sprint(v)) {
+					return "must match " + re.String()
+				}
+				return ""
+			}
+		case "email":
+			r = stringRule("must be an email address", func(s string) bool {
+				addr, err := mail.ParseAddress(s)
+				return err == nil && addr.Address == s
+			})
+		case "url":
+			r = stringRule("must be an absolute URL", func(s string) bool {
+				u, err := url.ParseRequestURI(s)
+				return err == nil && u.Scheme != "" && u.Host != ""
+			})
+		case "uuid":
+			r = stringRule("must be a UUID", func(s string) bool {
+				_, err := uuid.Parse(s)
+				return err == nil && len(s) == 36
+			})
+		default:
+			return fmt.Errorf("unknown rule %q", name)
+		}
+		if r != nil {
+			spec.rules = append(spec.rules, eachItem(r))
+		}
+	}
+	return nil
+}
+
+// eachItem applies r to every item of lists and to other values directly
+func eachItem(r rule) rule {
+	return func(v interface{}) string {
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice || rv.Type().Elem().Kind() == reflect.Uint8 {
+			return r(v)
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if reason := r(rv.Index(i).Interface()); reason != "" {
+				return fmt.Sprintf("item %d %s", i, reason)
+			}
+		}
+		return ""
+	}
+}
+
+func boundRule(name string, n float64) rule {
+	return func(v interface{}) string {
+		var actual float64
+		var ok bool
+		if name == "gte" || name == "lte" {
+			actual, ok = toFloat(v)
+			if !ok {
+				return "must be a number"
+			}
+		} else {
+			actual, ok = measure(v)
+			if !ok {
+				return "has no length or value to compare"
+			}
+		}
+
+		limit := strconv.FormatFloat(n, 'f', -1, 64)
+		switch name {
+		case "min", "gte":
+			if actual < n {
+				return "must be at least " + limit
+			}
+		case "max", "lte":
+			if actual > n {
+				return "must be at most " + limit
+			}
+		case "len":
+			if actual != n {
+				return "must have length " + limit
+			}
+		}
+		return ""
+	}
+}
+
+func oneOfRule(options []string) rule {
+	return func(v interface{}) string {
+		s := fmt.Sprint(v)
+		for _, option := range options {
+			if s == option {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Join(options, ", ")
+	}
+}
+
+func stringRule(reason string, ok func(string) bool) rule {
+	return func(v interface{}) string {
+		s, isString := v.(string)
+		if !isString || !ok(s) {
+			return reason
+		}
+		return ""
+	}
+}
+
+// measure returns the length of strings and collections, or the value of numbers
+func measure(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(rv.String())), true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(rv.Len()), true
+	}
+	return toFloat(v)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.String:
+		n, err := strconv.ParseFloat(rv.String(), 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// lookupPath resolves a dotted path such as "user.address.zip" in decoded
+// JSON. A literal key containing dots takes precedence over nesting.
+func lookupPath(root interface{}, path string) (interface{}, bool) {
+	if values, ok := root.(map[string]interface{}); ok {
+		if v, ok := values[path]; ok {
+			return v, true
+		}
+	}
+
+	current := root
+	for _, part := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			v, ok := node[part]
+			if !ok {
+				return nil, false
+			}
+			current = v
+		case []interface{}:
+			idx, err := strconv.Atoi(part)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil, false
+			}
+			current = node[idx]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// structRule validates one field of a struct given to Bind
+type structRule struct {
+	index []int
+	name  string
+	spec  parameterSpec
+}
+
+// compileStructRules collects the `validate` tags of typ, descending into
+// nested structs so their fields are reported as "parent.child".
+func compileStructRules(typ reflect.Type, index []int, prefix string) []structRule {
+	var rules []structRule
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		fieldIndex := append(append([]int(nil), index...), i)
+
+		name := prefix
+		if !field.Anonymous {
+			name = prefix + fieldName(field)
+		}
+
+		if tag, ok := field.Tag.Lookup("validate"); ok && tag != "-" {
+			spec := parameterSpec{key: name}
+			if err := spec.parse(tag); err != nil {
+				panic(fmt.Sprintf("middlewares: invalid validate tag on %s: %v", field.Name, err))
+			}
+			rules = append(rules, structRule{index: fieldIndex, name: name, spec: spec})
+		}
+
+		if field.Type.Kind() == reflect.Struct && field.Type != timeType {
+			nestedPrefix := prefix
+			if !field.Anonymous {
+				nestedPrefix = name + "."
+			}
+			rules = append(rules, compileStructRules(field.Type, fieldIndex, nestedPrefix)...)
+		}
+	}
+	return rules
+}
+
+// validateStruct checks rules against the fields of target. Fields count as
+// present when filled holds them, zero values included, so `gte=18` rejects
+// ?age=0 and `required` accepts it.
+func validateStruct(target reflect.Value, rules []structRule, filled presence) error {
+	var errs ValidationError
+	for _, r := range rules {
+		field := target.FieldByIndex(r.index)
+		present := filled.has(r.index) && !(field.Kind() == reflect.Ptr && field.IsNil())
+		var value interface{}
+		if present {
+			if field.Kind() == reflect.Ptr {
+				field = field.Elem()
+			}
+			value = field.Interface()
+		}
+		errs = r.spec.check(value, present, errs)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func fieldName(field reflect.StructField) string {
+	for _, key := range []string{"json", "query", "form", "path"} {
+		if name := tagName(field, key); name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
@@ -0,0 +1,60 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type rulesTestParams struct {
+	Age   int    `query:"age" validate:"gte=18"`
+	Count int    `query:"count" validate:"required"`
+	Name  string `json:"name" validate:"min=2"`
+	Limit *int   `json:"limit" validate:"lte=100"`
+}
+
+func TestBindRulesPresence(t *testing.T) {
+	cases := []struct {
+		query  string
+		body   string
+		status int
+	}{
+		{"count=1&age=30", `{"name":"jane"}`, http.StatusOK},
+		// a zero that was sent satisfies required
+		{"count=0", `{}`, http.StatusOK},
+		{"age=30", `{}`, http.StatusBadRequest},
+		// a zero that was sent is checked by the other rules
+		{"count=1&age=0", `{}`, http.StatusBadRequest},
+		{"count=1", `{"name":""}`, http.StatusBadRequest},
+		{"count=1", `{"limit":0}`, http.StatusOK},
+		{"count=1", `{"limit":101}`, http.StatusBadRequest},
+		{"count=1", `{"limit":null}`, http.StatusOK},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodPost, "/?"+c.query, strings.NewReader(c.body))
+		r.Header.Set("Content-Type", "application/json")
+		if code, _ := bindRequest(t, &rulesTestParams{}, r); code != c.status {
+			t.Errorf("?%s %s: status %d, want %d", c.query, c.body, code, c.status)
+		}
+	}
+}
+
+func TestRequireParametersInQueryRules(t *testing.T) {
+	handler := RequireParametersInQuery("age|int,gte=18", "sort|optional,oneof=asc desc")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	cases := map[string]int{
+		"age=18":           http.StatusOK,
+		"age=18&sort=desc": http.StatusOK,
+		"age=0":            http.StatusBadRequest,
+		"age=x":            http.StatusBadRequest,
+		"":                 http.StatusBadRequest,
+		"age=20&sort=up":   http.StatusBadRequest,
+	}
+	for query, status := range cases {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?"+query, nil))
+		if w.Code != status {
+			t.Errorf("?%s: status %d, want %d", query, w.Code, status)
+		}
+	}
+}
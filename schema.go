@@ -0,0 +1,127 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync"
+
+	"github.com/jeffguorg/middlewares/problem"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+var (
+	schemaCache sync.Map
+)
+
+// SchemaError lists every location of a body that does not match its schema
+type SchemaError struct {
+	err *jsonschema.ValidationError
+}
+
+func (e *SchemaError) Error() string {
+	return e.err.Error()
+}
+
+// InvalidParams implements problem.InvalidParamsError. Names are JSON
+// pointers into the body, e.g. "/user/address/zip".
+func (e *SchemaError) InvalidParams() []problem.InvalidParam {
+	var params []problem.InvalidParam
+	var walk func(*jsonschema.ValidationError)
+	walk = func(err *jsonschema.ValidationError) {
+		// inner nodes only say that a subschema failed, leaves say why
+		if len(err.Causes) == 0 {
+			name := err.InstanceLocation
+			if name == "" {
+				name = "/"
+			}
+			params = append(params, problem.InvalidParam{Name: name, Reason: err.Message})
+		}
+		for _, cause := range err.Causes {
+			walk(cause)
+		}
+	}
+	walk(e.err)
+	return params
+}
+
+// CompileJSONSchema compiles a draft-07 or 2020-12 schema document, the
+// draft being picked from $schema and defaulting to 2020-12. name identifies
+// the document for $ref resolution. Compiled schemas are cached by content.
+func CompileJSONSchema(name string, document []byte) (*jsonschema.Schema, error) {
+	digest := sha256.Sum256(document)
+	cacheKey := name + "#" + hex.EncodeToString(digest[:])
+	if schema, ok := schemaCache.Load(cacheKey); ok {
+		return schema.(*jsonschema.Schema), nil
+	}
+
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(name, bytes.NewReader(document)); err != nil {
+		return nil, err
+	}
+	schema, err := compiler.Compile(name)
+	if err != nil {
+		return nil, err
+	}
+	schemaCache.Store(cacheKey, schema)
+	return schema, nil
+}
+
+// LoadJSONSchema reads and compiles the schema stored at path
+func LoadJSONSchema(path string) (*jsonschema.Schema, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	document, err := ioutil.ReadFile(abs)
+	if err != nil {
+		return nil, err
+	}
+	return CompileJSONSchema("file://"+filepath.ToSlash(abs), document)
+}
+
+// RequireJSONSchema validates the JSON body against schema, reusing the body
+// saved by StoreBodyInContext if any. Failures are reported per JSON pointer.
+// Members of an object body are stored in context like RequireParametersInJSON
+// does.
+func RequireJSONSchema(schema *jsonschema.Schema) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			buf, err := readBody(r)
+			if err != nil {
+				problem.Respond(w, r, http.StatusInternalServerError, err)
+				return
+			}
+
+			var body interface{}
+			if err := json.Unmarshal(buf, &body); err != nil {
+				problem.Respond(w, r, http.StatusBadRequest, fmt.Errorf("malformed JSON body: %v", err))
+				return
+			}
+
+			if err := schema.Validate(body); err != nil {
+				if validationErr, ok := err.(*jsonschema.ValidationError); ok {
+					problem.Respond(w, r, http.StatusBadRequest, &SchemaError{err: validationErr})
+					return
+				}
+				problem.Respond(w, r, http.StatusInternalServerError, err)
+				return
+			}
+
+			values, ok := body.(map[string]interface{})
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			params := make(parameterStore, len(values))
+			for k, v := range values {
+				params[k] = storedParameter{Value: v, Source: SourceJSON}
+			}
+			next.ServeHTTP(w, r.WithContext(withParameters(r, params)))
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jeffguorg/middlewares/problem"
+)
+
+const testSchema = `{
+	"$schema": "http://json-schema.org/draft-07/schema#",
+	"type": "object",
+	"required": ["name"],
+	"properties": {
+		"name": {"type": "string", "minLength": 1},
+		"age": {"type": "integer", "minimum": 0},
+		"address": {"$ref": "#/definitions/address"}
+	},
+	"definitions": {
+		"address": {
+			"type": "object",
+			"properties": {"zip": {"type": "string", "pattern": "^[0-9]{5}$"}}
+		}
+	}
+}`
+
+func TestRequireJSONSchema(t *testing.T) {
+	schema, err := CompileJSONSchema("person.json", []byte(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached, _ := CompileJSONSchema("person.json", []byte(testSchema)); cached != schema {
+		t.Error("schema compiled twice")
+	}
+
+	var name interface{}
+	handler := RequireJSONSchema(schema)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name = Parameter(r, "name")
+	}))
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		return w
+	}
+
+	if w := post(`{"name": "jane", "age": 0, "address": {"zip": "75001"}}`); w.Code != http.StatusOK || name != "jane" {
+		t.Fatalf("valid body: status %d, name %v", w.Code, name)
+	}
+
+	w := post(`{"name": "jane", "age": -1, "address": {"zip": "7500"}}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid body: status %d", w.Code)
+	}
+	var details problem.Details
+	if err := json.Unmarshal(w.Body.Bytes(), &details); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, param := range details.InvalidParams {
+		names = append(names, param.Name)
+	}
+	sort.Strings(names)
+	if strings.Join(names, " ") != "/address/zip /age" {
+		t.Errorf("invalid params %v", details.InvalidParams)
+	}
+
+	for _, body := range []string{`{"age": 3}`, `{"name": ""}`, `["jane"]`, `{"name": `} {
+		if w := post(body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d", body, w.Code)
+		}
+	}
+}
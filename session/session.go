@@ -7,6 +7,7 @@ import (
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
+	"github.com/jeffguorg/middlewares/problem"
 )
 
 const (
@@ -121,7 +122,8 @@ func (mixin Mixin) EnsureSession(next http.Handler) http.Handler {
 
 			tokenStr, err := jwtToken.SignedString([]byte(mixin.JWTKey))
 			if err != nil {
-				panic(err)
+				problem.Respond(rw, r, http.StatusInternalServerError, err)
+				return
 			}
 
 			// set session id to cookie
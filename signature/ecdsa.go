@@ -0,0 +1,113 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"math/big"
+)
+
+// SigningMethodECDSA signs with ECDSA on P-256, P-384 or P-521. Signatures
+// are the fixed size r || s concatenation used by JWS. A method holding only
+// PublicKey can verify but not sign.
+type SigningMethodECDSA struct {
+	PrivateKey *ecdsa.PrivateKey
+	PublicKey  *ecdsa.PublicKey
+	HashMethod crypto.Hash
+}
+
+func (method SigningMethodECDSA) publicKey() *ecdsa.PublicKey {
+	if method.PublicKey != nil {
+		return method.PublicKey
+	}
+	if method.PrivateKey != nil {
+		return &method.PrivateKey.PublicKey
+	}
+	return nil
+}
+
+func (method SigningMethodECDSA) digest(signingString string) ([]byte, error) {
+	if !method.HashMethod.Available() {
+		return nil, ErrHashUnavailable
+	}
+	hasher := method.HashMethod.New()
+	hasher.Write([]byte(signingString))
+	return hasher.Sum(nil), nil
+}
+
+func curveKeySize(curve elliptic.Curve) int {
+	return (curve.Params().BitSize + 7) / 8
+}
+
+func (method SigningMethodECDSA) Verify(signingString, signature string) error {
+	key := method.publicKey()
+	if key == nil {
+		return ErrKeyUnavailable
+	}
+	sig, err := rawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	return method.VerifyBytes(signingString, sig)
+}
+
+// VerifyBytes implements ByteSigningMethod. sig is r and s concatenated,
+// each padded to the curve size.
+func (method SigningMethodECDSA) VerifyBytes(signingString string, sig []byte) error {
+	key := method.publicKey()
+	if key == nil {
+		return ErrKeyUnavailable
+	}
+	size := curveKeySize(key.Curve)
+	if len(sig) != 2*size {
+		return ErrSignatureInvalid
+	}
+	digest, err := method.digest(signingString)
+	if err != nil {
+		return err
+	}
+
+	r := new(big.Int).SetBytes(sig[:size])
+	s := new(big.Int).SetBytes(sig[size:])
+	if !ecdsa.Verify(key, digest, r, s) {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+func (method SigningMethodECDSA) Sign(signingString string) (string, error) {
+	sig, err := method.SignBytes(signingString)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// SignBytes implements ByteSigningMethod
+func (method SigningMethodECDSA) SignBytes(signingString string) ([]byte, error) {
+	if method.PrivateKey == nil {
+		return nil, ErrKeyUnavailable
+	}
+	digest, err := method.digest(signingString)
+	if err != nil {
+		return nil, err
+	}
+
+	r, s, err := ecdsa.Sign(rand.Reader, method.PrivateKey, digest)
+	if err != nil {
+		return nil, err
+	}
+	size := curveKeySize(method.PrivateKey.Curve)
+	sig := make([]byte, 2*size)
+	rBytes, sBytes := r.Bytes(), s.Bytes()
+	copy(sig[size-len(rBytes):size], rBytes)
+	copy(sig[2*size-len(sBytes):], sBytes)
+	return sig, nil
+}
+
+var (
+	_ SigningMethod     = SigningMethodECDSA{}
+	_ ByteSigningMethod = SigningMethodECDSA{}
+)
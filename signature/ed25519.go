@@ -0,0 +1,68 @@
+package signature
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+)
+
+// SigningMethodEd25519 signs with Ed25519. A method holding only PublicKey
+// can verify but not sign.
+type SigningMethodEd25519 struct {
+	PrivateKey ed25519.PrivateKey
+	PublicKey  ed25519.PublicKey
+}
+
+func (method SigningMethodEd25519) publicKey() ed25519.PublicKey {
+	if len(method.PublicKey) == ed25519.PublicKeySize {
+		return method.PublicKey
+	}
+	if len(method.PrivateKey) == ed25519.PrivateKeySize {
+		return method.PrivateKey.Public().(ed25519.PublicKey)
+	}
+	return nil
+}
+
+func (method SigningMethodEd25519) Verify(signingString, signature string) error {
+	key := method.publicKey()
+	if key == nil {
+		return ErrKeyUnavailable
+	}
+	sig, err := rawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	return method.VerifyBytes(signingString, sig)
+}
+
+// VerifyBytes implements ByteSigningMethod
+func (method SigningMethodEd25519) VerifyBytes(signingString string, sig []byte) error {
+	key := method.publicKey()
+	if key == nil {
+		return ErrKeyUnavailable
+	}
+	if !ed25519.Verify(key, []byte(signingString), sig) {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+func (method SigningMethodEd25519) Sign(signingString string) (string, error) {
+	sig, err := method.SignBytes(signingString)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// SignBytes implements ByteSigningMethod
+func (method SigningMethodEd25519) SignBytes(signingString string) ([]byte, error) {
+	if len(method.PrivateKey) != ed25519.PrivateKeySize {
+		return nil, ErrKeyUnavailable
+	}
+	return ed25519.Sign(method.PrivateKey, []byte(signingString)), nil
+}
+
+var (
+	_ SigningMethod     = SigningMethodEd25519{}
+	_ ByteSigningMethod = SigningMethodEd25519{}
+)
@@ -4,39 +4,113 @@ import (
 	"crypto"
 	"crypto/hmac"
 	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
 	"github.com/go-errors/errors"
 )
 
 var (
 	ErrHashUnavailable  = errors.New("the requested hash function is unavailable")
 	ErrSignatureInvalid = errors.New("signature is invalid")
+	ErrKeyUnavailable   = errors.New("the key required for this operation is unavailable")
 )
 
+// Encoding converts signatures between bytes and text. *base64.Encoding
+// implements it; use the Strict form, e.g. base64.StdEncoding.Strict(), so a
+// signature has a single accepted spelling.
+type Encoding interface {
+	EncodeToString(src []byte) string
+	DecodeString(s string) ([]byte, error)
+}
+
+type hexEncoding struct{}
+
+func (hexEncoding) EncodeToString(src []byte) string {
+	return hex.EncodeToString(src)
+}
+
+func (hexEncoding) DecodeString(s string) ([]byte, error) {
+	return hex.DecodeString(s)
+}
+
+// HexEncoding encodes signatures as lowercase hex and decodes either case
+var HexEncoding Encoding = hexEncoding{}
+
+// rawURLEncoding is the encoding of the signatures of this package. Strict
+// decoding rejects non-zero padding bits, so a signature has one spelling.
+var rawURLEncoding = base64.RawURLEncoding.Strict()
+
+// SigningMethodHMAC signs with HMAC. Signatures are Prefix followed by the
+// MAC in Encoding, e.g. Prefix "sha256=" and HexEncoding for GitHub webhooks.
 type SigningMethodHMAC struct {
 	Key        []byte
 	HashMethod crypto.Hash
+
+	// Encoding of the MAC, strict base64.RawURLEncoding when nil
+	Encoding Encoding
+
+	// Prefix is required in front of verified signatures and added to
+	// produced ones
+	Prefix string
+}
+
+func (method SigningMethodHMAC) encoding() Encoding {
+	if method.Encoding == nil {
+		return rawURLEncoding
+	}
+	return method.Encoding
+}
+
+// Sum returns the raw MAC of signingString
+func (method SigningMethodHMAC) Sum(signingString string) ([]byte, error) {
+	if !method.HashMethod.Available() {
+		return nil, ErrHashUnavailable
+	}
+
+	hasher := hmac.New(method.HashMethod.New, method.Key)
+	hasher.Write([]byte(signingString))
+	return hasher.Sum(nil), nil
 }
 
+// Verify decodes signature and compares it with the MAC in constant time
 func (method SigningMethodHMAC) Verify(signingString, signature string) error {
-	if calculated, err := method.Sign(signingString); err != nil {
+	if !strings.HasPrefix(signature, method.Prefix) {
+		return ErrSignatureInvalid
+	}
+	received, err := method.encoding().DecodeString(signature[len(method.Prefix):])
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	return method.VerifyBytes(signingString, received)
+}
+
+// VerifyBytes compares the raw MAC with signature in constant time
+func (method SigningMethodHMAC) VerifyBytes(signingString string, received []byte) error {
+	calculated, err := method.Sum(signingString)
+	if err != nil {
 		return err
-	} else if calculated != signature {
+	}
+	if !hmac.Equal(calculated, received) {
 		return ErrSignatureInvalid
 	}
 	return nil
 }
 
 func (method SigningMethodHMAC) Sign(signingString string) (string, error) {
-	if !method.HashMethod.Available() {
-		return "", ErrHashUnavailable
+	sum, err := method.Sum(signingString)
+	if err != nil {
+		return "", err
 	}
+	return method.Prefix + method.encoding().EncodeToString(sum), nil
+}
 
-	hasher := hmac.New(method.HashMethod.New, method.Key)
-	hasher.Write([]byte(signingString))
-
-	return base64.RawURLEncoding.EncodeToString(hasher.Sum(nil)), nil
+// SignBytes returns the raw MAC, like Sum
+func (method SigningMethodHMAC) SignBytes(signingString string) ([]byte, error) {
+	return method.Sum(signingString)
 }
 
 var (
-	_ SigningMethod = SigningMethodHMAC{}
+	_ SigningMethod     = SigningMethodHMAC{}
+	_ ByteSigningMethod = SigningMethodHMAC{}
 )
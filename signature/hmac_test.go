@@ -0,0 +1,56 @@
+package signature
+
+import (
+	"crypto"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestSigningMethodHMAC(t *testing.T) {
+	// RFC 4231 test case 2
+	const mac = "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+	methods := map[string]SigningMethodHMAC{
+		"hex":    {Key: []byte("Jefe"), HashMethod: crypto.SHA256, Encoding: HexEncoding, Prefix: "sha256="},
+		"base64": {Key: []byte("Jefe"), HashMethod: crypto.SHA256, Encoding: base64.StdEncoding.Strict()},
+		"raw":    {Key: []byte("Jefe"), HashMethod: crypto.SHA256},
+	}
+	for name, method := range methods {
+		sign, err := method.Sign("what do ya want for nothing?")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name == "hex" && sign != "sha256="+mac {
+			t.Errorf("hex: %s", sign)
+		}
+		if err := method.Verify("what do ya want for nothing?", sign); err != nil {
+			t.Errorf("%s: signature rejected: %v", name, err)
+		}
+		if err := method.Verify("what do ya want for something?", sign); err != ErrSignatureInvalid {
+			t.Errorf("%s: tampered message: %v", name, err)
+		}
+		if name != "hex" {
+			if err := method.Verify("what do ya want for nothing?", flipPaddingBit(sign)); err != ErrSignatureInvalid {
+				t.Errorf("%s: signature with padding bits set: %v", name, err)
+			}
+		}
+	}
+
+	method := methods["hex"]
+	message := "what do ya want for nothing?"
+	if err := method.Verify(message, "sha256="+strings.ToUpper(mac)); err != nil {
+		t.Errorf("uppercase hex rejected: %v", err)
+	}
+	if err := method.Verify(message, mac); err != ErrSignatureInvalid {
+		t.Errorf("signature without prefix: %v", err)
+	}
+	if err := method.Verify(message, "sha256="+mac[:62]); err != ErrSignatureInvalid {
+		t.Errorf("truncated signature: %v", err)
+	}
+	if err := method.Verify(message, "sha256=not hex"); err != ErrSignatureInvalid {
+		t.Errorf("undecodable signature: %v", err)
+	}
+	if _, err := (SigningMethodHMAC{Key: []byte("Jefe"), HashMethod: crypto.Hash(0)}).Sign(message); err != ErrHashUnavailable {
+		t.Errorf("unavailable hash: %v", err)
+	}
+}
@@ -0,0 +1,438 @@
+/*
+Package httpsig implements HTTP Message Signatures (RFC 9421) on top of the
+signature.SigningMethod interface.
+
+The Signature header carries raw signature bytes, so keys must implement
+signature.ByteSigningMethod, as the HMAC, RSA, ECDSA and Ed25519 methods of
+package signature do. The Prefix and Encoding of SigningMethodHMAC are
+ignored. Methods that only produce text signatures, such as a KeyRing, are
+rejected with ErrMethodUnsupported; use its keys through a KeyStore instead.
+*/
+package httpsig
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/jeffguorg/middlewares/signature"
+)
+
+var (
+	ErrSignatureMissing   = errors.New("message signature is missing")
+	ErrSignatureMalformed = errors.New("message signature is malformed")
+	ErrSignatureExpired   = errors.New("message signature has expired")
+	ErrSignatureTooOld    = errors.New("message signature is too old")
+	ErrSignatureFuture    = errors.New("message signature is created in the future")
+	ErrComponentMissing   = errors.New("required component is not covered by the signature")
+	ErrKeyNotFound        = errors.New("signing key is not found")
+	ErrMethodUnsupported  = errors.New("signing method cannot sign raw bytes")
+)
+
+// KeyStore resolves the keyid parameter of a signature to its signing method
+type KeyStore interface {
+	LookupKey(keyID string) (signature.SigningMethod, error)
+}
+
+// KeyStoreFunc adapts a function to KeyStore
+type KeyStoreFunc func(keyID string) (signature.SigningMethod, error)
+
+// LookupKey calls f(keyID)
+func (f KeyStoreFunc) LookupKey(keyID string) (signature.SigningMethod, error) {
+	return f(keyID)
+}
+
+// StaticKeys is a fixed set of keys indexed by key id
+type StaticKeys map[string]signature.SigningMethod
+
+// LookupKey implements KeyStore
+func (keys StaticKeys) LookupKey(keyID string) (signature.SigningMethod, error) {
+	if method, ok := keys[keyID]; ok {
+		return method, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+// Verifier checks the signatures of incoming requests
+type Verifier struct {
+	Keys KeyStore
+
+	// Label selects the signature to verify, the first one of
+	// Signature-Input is used when empty
+	Label string
+
+	// Required lists the components every accepted signature must cover,
+	// e.g. "@method", "@path" or "content-digest"
+	Required []string
+
+	// MaxAge rejects signatures created longer ago, 0 disables the check.
+	// created becomes mandatory when set.
+	MaxAge time.Duration
+
+	// ClockSkew is tolerated on created and expires
+	ClockSkew time.Duration
+
+	// Now returns the current time, time.Now when nil
+	Now func() time.Time
+}
+
+// Verify checks the message signature of r and returns the key id it was
+// signed with
+func (v Verifier) Verify(r *http.Request) (string, error) {
+	inputHeader := strings.Join(r.Header.Values("Signature-Input"), ", ")
+	signatureHeader := strings.Join(r.Header.Values("Signature"), ", ")
+	if inputHeader == "" || signatureHeader == "" {
+		return "", ErrSignatureMissing
+	}
+
+	inputs, err := parseDictionary(inputHeader)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrSignatureMalformed, err)
+	}
+	signatures, err := parseDictionary(signatureHeader)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrSignatureMalformed, err)
+	}
+
+	input, ok := findMember(inputs, v.Label)
+	if !ok || input.item != nil {
+		return "", ErrSignatureMissing
+	}
+	sigMember, ok := findMember(signatures, input.key)
+	if !ok || sigMember.item == nil {
+		return "", ErrSignatureMissing
+	}
+	sig, ok := sigMember.item.value.([]byte)
+	if !ok {
+		return "", ErrSignatureMalformed
+	}
+
+	for _, required := range v.Required {
+		if !covers(input.list, required) {
+			return "", fmt.Errorf("%w: %s", ErrComponentMissing, required)
+		}
+	}
+
+	if err := v.checkTime(input.params); err != nil {
+		return "", err
+	}
+
+	if v.Keys == nil {
+		return "", ErrKeyNotFound
+	}
+	keyID, _ := input.params.get("keyid")
+	keyIDString, _ := keyID.(string)
+	method, err := v.Keys.LookupKey(keyIDString)
+	if err != nil {
+		return "", err
+	}
+
+	base, err := signatureBase(r, input.list, input.params)
+	if err != nil {
+		return "", err
+	}
+	byteMethod, err := bytesOf(method)
+	if err != nil {
+		return "", err
+	}
+	if err := byteMethod.VerifyBytes(base, sig); err != nil {
+		return "", err
+	}
+	return keyIDString, nil
+}
+
+func (v Verifier) checkTime(ps params) error {
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+
+	if value, ok := ps.get("expires"); ok {
+		expires, ok := value.(int64)
+		if !ok {
+			return ErrSignatureMalformed
+		}
+		if now.After(time.Unix(expires, 0).Add(v.ClockSkew)) {
+			return ErrSignatureExpired
+		}
+	}
+
+	value, ok := ps.get("created")
+	if !ok {
+		if v.MaxAge > 0 {
+			return fmt.Errorf("%w: created is required", ErrSignatureMalformed)
+		}
+		return nil
+	}
+	created, ok := value.(int64)
+	if !ok {
+		return ErrSignatureMalformed
+	}
+	createdAt := time.Unix(created, 0)
+	if createdAt.After(now.Add(v.ClockSkew)) {
+		return ErrSignatureFuture
+	}
+	if v.MaxAge > 0 && now.Sub(createdAt) > v.MaxAge+v.ClockSkew {
+		return ErrSignatureTooOld
+	}
+	return nil
+}
+
+// bytesOf returns method as a signature.ByteSigningMethod
+func bytesOf(method signature.SigningMethod) (signature.ByteSigningMethod, error) {
+	if byteMethod, ok := method.(signature.ByteSigningMethod); ok {
+		return byteMethod, nil
+	}
+	return nil, fmt.Errorf("%w: %T", ErrMethodUnsupported, method)
+}
+
+func findMember(members []member, key string) (member, bool) {
+	for _, m := range members {
+		if key == "" || m.key == key {
+			return m, true
+		}
+	}
+	return member{}, false
+}
+
+func covers(items []item, name string) bool {
+	for _, it := range items {
+		if s, ok := it.value.(string); ok && s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Signer adds Signature-Input and Signature headers to outgoing requests
+type Signer struct {
+	KeyID  string
+	Method signature.SigningMethod
+
+	// Alg is sent as the alg parameter when not empty
+	Alg string
+
+	// Label names the signature, "sig1" when empty
+	Label string
+
+	// Components lists the covered components. Plain names such as
+	// "@method" or "content-type" are accepted, as are serialized
+	// components with parameters like `"@query-param";name="id"`. When
+	// empty, @method, @authority and @path are covered, plus content-type
+	// and content-digest if the request has them.
+	Components []string
+
+	// Expires adds an expires parameter that far after created when set
+	Expires time.Duration
+
+	// Now returns the current time, time.Now when nil
+	Now func() time.Time
+}
+
+// Sign signs r in place
+func (s Signer) Sign(r *http.Request) error {
+	components := s.Components
+	if len(components) == 0 {
+		components = []string{"@method", "@authority", "@path"}
+		for _, header := range []string{"content-type", "content-digest"} {
+			if r.Header.Get(header) != "" {
+				components = append(components, header)
+			}
+		}
+	}
+
+	items := make([]item, 0, len(components))
+	for _, component := range components {
+		it, err := parseComponent(component)
+		if err != nil {
+			return err
+		}
+		items = append(items, it)
+	}
+
+	now := time.Now()
+	if s.Now != nil {
+		now = s.Now()
+	}
+	ps := params{{key: "created", value: now.Unix()}}
+	if s.Expires > 0 {
+		ps = append(ps, param{key: "expires", value: now.Add(s.Expires).Unix()})
+	}
+	if s.KeyID != "" {
+		ps = append(ps, param{key: "keyid", value: s.KeyID})
+	}
+	if s.Alg != "" {
+		ps = append(ps, param{key: "alg", value: s.Alg})
+	}
+
+	base, err := signatureBase(r, items, ps)
+	if err != nil {
+		return err
+	}
+	method, err := bytesOf(s.Method)
+	if err != nil {
+		return err
+	}
+	sig, err := method.SignBytes(base)
+	if err != nil {
+		return err
+	}
+
+	label := s.Label
+	if label == "" {
+		label = "sig1"
+	}
+	var sigValue strings.Builder
+	serializeBareItem(&sigValue, sig)
+	r.Header.Set("Signature-Input", label+"="+serializeInnerList(items, ps))
+	r.Header.Set("Signature", label+"="+sigValue.String())
+	return nil
+}
+
+// Transport is an http.RoundTripper signing every request with Signer
+type Transport struct {
+	Signer Signer
+
+	// Base performs the requests, http.DefaultTransport when nil
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	// a RoundTripper must not modify the request it is given
+	signed := r.Clone(r.Context())
+	if err := t.Signer.Sign(signed); err != nil {
+		return nil, err
+	}
+	return base.RoundTrip(signed)
+}
+
+func parseComponent(component string) (item, error) {
+	if !strings.HasPrefix(component, `"`) {
+		return item{value: strings.ToLower(component)}, nil
+	}
+	p := &parser{s: component}
+	it, err := p.parseItem()
+	if err != nil {
+		return item{}, err
+	}
+	if !p.eof() {
+		return item{}, p.errorf("trailing characters")
+	}
+	return it, nil
+}
+
+// signatureBase builds the signature base of RFC 9421 section 2.5
+func signatureBase(r *http.Request, items []item, ps params) (string, error) {
+	var b strings.Builder
+	for _, it := range items {
+		value, err := componentValue(r, it)
+		if err != nil {
+			return "", err
+		}
+		serializeItem(&b, it)
+		b.WriteString(": ")
+		b.WriteString(value)
+		b.WriteByte('\n')
+	}
+	b.WriteString(`"@signature-params": `)
+	b.WriteString(serializeInnerList(items, ps))
+	return b.String(), nil
+}
+
+func componentValue(r *http.Request, it item) (string, error) {
+	name, ok := it.value.(string)
+	if !ok || name != strings.ToLower(name) {
+		return "", fmt.Errorf("%w: invalid component identifier", ErrSignatureMalformed)
+	}
+	for _, p := range it.params {
+		if p.key != "name" {
+			return "", fmt.Errorf("%w: unsupported component parameter %s", ErrSignatureMalformed, p.key)
+		}
+	}
+
+	switch name {
+	case "@method":
+		return r.Method, nil
+	case "@authority":
+		return authority(r), nil
+	case "@scheme":
+		return scheme(r), nil
+	case "@target-uri":
+		return scheme(r) + "://" + authority(r) + r.URL.RequestURI(), nil
+	case "@request-target":
+		return r.URL.RequestURI(), nil
+	case "@path":
+		if path := r.URL.EscapedPath(); path != "" {
+			return path, nil
+		}
+		return "/", nil
+	case "@query":
+		return "?" + r.URL.RawQuery, nil
+	case "@query-param":
+		paramName, _ := it.params.get("name")
+		key, ok := paramName.(string)
+		if !ok {
+			return "", fmt.Errorf("%w: @query-param requires a name", ErrSignatureMalformed)
+		}
+		values := r.URL.Query()[key]
+		if len(values) != 1 {
+			return "", fmt.Errorf("%w: query parameter %s", ErrComponentMissing, key)
+		}
+		return strings.ReplaceAll(url.QueryEscape(values[0]), "+", "%20"), nil
+	case "@status", "@signature-params":
+		return "", fmt.Errorf("%w: %s cannot be covered in requests", ErrSignatureMalformed, name)
+	}
+	if strings.HasPrefix(name, "@") {
+		return "", fmt.Errorf("%w: unknown derived component %s", ErrSignatureMalformed, name)
+	}
+
+	if name == "host" {
+		return authority(r), nil
+	}
+	values := r.Header.Values(name)
+	if len(values) == 0 {
+		return "", fmt.Errorf("%w: header %s", ErrComponentMissing, name)
+	}
+	trimmed := make([]string, len(values))
+	for i, value := range values {
+		trimmed[i] = strings.TrimSpace(value)
+	}
+	return strings.Join(trimmed, ", "), nil
+}
+
+func scheme(r *http.Request) string {
+	if r.URL.Scheme != "" {
+		return strings.ToLower(r.URL.Scheme)
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// authority returns the lowercased host without the default port
+func authority(r *http.Request) string {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	host = strings.ToLower(host)
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if (port == "80" && scheme(r) == "http") || (port == "443" && scheme(r) == "https") {
+			if strings.Contains(h, ":") {
+				return "[" + h + "]"
+			}
+			return h
+		}
+	}
+	return host
+}
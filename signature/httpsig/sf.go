@@ -0,0 +1,363 @@
+package httpsig
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The subset of RFC 8941 structured fields needed by Signature-Input and
+// Signature: dictionaries whose members are items or inner lists.
+
+type token string
+
+type param struct {
+	key   string
+	value interface{}
+}
+
+type params []param
+
+func (ps params) get(key string) (interface{}, bool) {
+	for _, p := range ps {
+		if p.key == key {
+			return p.value, true
+		}
+	}
+	return nil, false
+}
+
+type item struct {
+	value  interface{} // string, token, int64, []byte or bool
+	params params
+}
+
+type member struct {
+	key    string
+	item   *item
+	list   []item
+	params params // parameters of the inner list
+}
+
+type parser struct {
+	s   string
+	pos int
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *parser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *parser) skipSP() {
+	for !p.eof() && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *parser) skipOWS() {
+	for !p.eof() && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("structured field at %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func parseDictionary(s string) ([]member, error) {
+	p := &parser{s: s}
+	var members []member
+	p.skipSP()
+	for !p.eof() {
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		m := member{key: key}
+		if p.peek() == '=' {
+			p.pos++
+			if p.peek() == '(' {
+				m.list, m.params, err = p.parseInnerList()
+			} else {
+				var it item
+				it, err = p.parseItem()
+				m.item = &it
+			}
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			ps, err := p.parseParams()
+			if err != nil {
+				return nil, err
+			}
+			m.item = &item{value: true, params: ps}
+		}
+
+		// later members override earlier ones with the same key
+		replaced := false
+		for i := range members {
+			if members[i].key == key {
+				members[i] = m
+				replaced = true
+			}
+		}
+		if !replaced {
+			members = append(members, m)
+		}
+
+		p.skipOWS()
+		if p.eof() {
+			break
+		}
+		if p.peek() != ',' {
+			return nil, p.errorf("expecting ','")
+		}
+		p.pos++
+		p.skipOWS()
+		if p.eof() {
+			return nil, p.errorf("trailing ','")
+		}
+	}
+	return members, nil
+}
+
+func (p *parser) parseKey() (string, error) {
+	start := p.pos
+	c := p.peek()
+	if !(c >= 'a' && c <= 'z') && c != '*' {
+		return "", p.errorf("invalid key")
+	}
+	for !p.eof() {
+		c := p.peek()
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_' || c == '-' || c == '.' || c == '*' {
+			p.pos++
+			continue
+		}
+		break
+	}
+	return p.s[start:p.pos], nil
+}
+
+func (p *parser) parseInnerList() ([]item, params, error) {
+	p.pos++ // (
+	var items []item
+	for !p.eof() {
+		p.skipSP()
+		if p.peek() == ')' {
+			p.pos++
+			ps, err := p.parseParams()
+			return items, ps, err
+		}
+		it, err := p.parseItem()
+		if err != nil {
+			return nil, nil, err
+		}
+		items = append(items, it)
+		if c := p.peek(); c != ' ' && c != ')' {
+			return nil, nil, p.errorf("expecting ' ' or ')'")
+		}
+	}
+	return nil, nil, p.errorf("unterminated inner list")
+}
+
+func (p *parser) parseItem() (item, error) {
+	value, err := p.parseBareItem()
+	if err != nil {
+		return item{}, err
+	}
+	ps, err := p.parseParams()
+	return item{value: value, params: ps}, err
+}
+
+func (p *parser) parseParams() (params, error) {
+	var ps params
+	for p.peek() == ';' {
+		p.pos++
+		p.skipSP()
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		var value interface{} = true
+		if p.peek() == '=' {
+			p.pos++
+			value, err = p.parseBareItem()
+			if err != nil {
+				return nil, err
+			}
+		}
+		ps = append(ps, param{key: key, value: value})
+	}
+	return ps, nil
+}
+
+func (p *parser) parseBareItem() (interface{}, error) {
+	c := p.peek()
+	switch {
+	case c == '"':
+		return p.parseString()
+	case c == ':':
+		return p.parseBytes()
+	case c == '?':
+		p.pos++
+		switch p.peek() {
+		case '1':
+			p.pos++
+			return true, nil
+		case '0':
+			p.pos++
+			return false, nil
+		}
+		return nil, p.errorf("invalid boolean")
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.parseInteger()
+	case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '*':
+		return p.parseToken(), nil
+	}
+	return nil, p.errorf("unexpected %q", c)
+}
+
+func (p *parser) parseString() (string, error) {
+	p.pos++ // "
+	var b strings.Builder
+	for !p.eof() {
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == '\\':
+			if p.eof() {
+				return "", p.errorf("unterminated escape")
+			}
+			next := p.s[p.pos]
+			if next != '"' && next != '\\' {
+				return "", p.errorf("invalid escape")
+			}
+			b.WriteByte(next)
+			p.pos++
+		case c == '"':
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return "", p.errorf("invalid string character")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *parser) parseBytes() ([]byte, error) {
+	p.pos++ // :
+	end := strings.IndexByte(p.s[p.pos:], ':')
+	if end < 0 {
+		return nil, p.errorf("unterminated byte sequence")
+	}
+	encoded := p.s[p.pos : p.pos+end]
+	p.pos += end + 1
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		// padding is optional when parsing
+		decoded, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	}
+	if err != nil {
+		return nil, p.errorf("invalid byte sequence")
+	}
+	return decoded, nil
+}
+
+func (p *parser) parseInteger() (int64, error) {
+	start := p.pos
+	if p.peek() == '-' {
+		p.pos++
+	}
+	for !p.eof() && p.s[p.pos] >= '0' && p.s[p.pos] <= '9' {
+		p.pos++
+	}
+	if p.peek() == '.' {
+		return 0, p.errorf("decimals are not supported")
+	}
+	n, err := strconv.ParseInt(p.s[start:p.pos], 10, 64)
+	if err != nil {
+		return 0, p.errorf("invalid integer")
+	}
+	return n, nil
+}
+
+func (p *parser) parseToken() token {
+	start := p.pos
+	for !p.eof() {
+		c := p.s[p.pos]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte("\"(),;<=>?@[\\]{}", c) >= 0 {
+			break
+		}
+		p.pos++
+	}
+	return token(p.s[start:p.pos])
+}
+
+func serializeBareItem(b *strings.Builder, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		b.WriteByte('"')
+		for i := 0; i < len(v); i++ {
+			if v[i] == '"' || v[i] == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(v[i])
+		}
+		b.WriteByte('"')
+	case token:
+		b.WriteString(string(v))
+	case int64:
+		b.WriteString(strconv.FormatInt(v, 10))
+	case []byte:
+		b.WriteByte(':')
+		b.WriteString(base64.StdEncoding.EncodeToString(v))
+		b.WriteByte(':')
+	case bool:
+		if v {
+			b.WriteString("?1")
+		} else {
+			b.WriteString("?0")
+		}
+	}
+}
+
+func serializeParams(b *strings.Builder, ps params) {
+	for _, p := range ps {
+		b.WriteByte(';')
+		b.WriteString(p.key)
+		if v, ok := p.value.(bool); ok && v {
+			continue
+		}
+		b.WriteByte('=')
+		serializeBareItem(b, p.value)
+	}
+}
+
+func serializeItem(b *strings.Builder, it item) {
+	serializeBareItem(b, it.value)
+	serializeParams(b, it.params)
+}
+
+func serializeInnerList(items []item, ps params) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for i, it := range items {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		serializeItem(&b, it)
+	}
+	b.WriteByte(')')
+	serializeParams(&b, ps)
+	return b.String()
+}
@@ -0,0 +1,278 @@
+package signature
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+// KeyIDSeparator separates the key id from the signature in the signatures
+// of a KeyRing. It is not part of the base64url or hex alphabets.
+const KeyIDSeparator = "."
+
+var (
+	ErrKeyUnknown  = errors.New("key id is unknown")
+	ErrKeyInactive = errors.New("key is not valid at this time")
+)
+
+// Key is a signing method with an id and an optional validity period
+type Key struct {
+	ID     string
+	Method SigningMethod
+
+	// NotBefore and NotAfter bound the validity of the key, zero values
+	// leave that side open
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+func (key Key) validAt(t time.Time) bool {
+	return (key.NotBefore.IsZero() || !t.Before(key.NotBefore)) && (key.NotAfter.IsZero() || !t.After(key.NotAfter))
+}
+
+// KeyLoader returns the keys of a KeyRing and the id of the key to sign with
+type KeyLoader func() (keys []Key, active string, err error)
+
+// KeyRing signs with its active key and verifies with any key in the ring.
+// Signatures are prefixed with the key id and KeyIDSeparator, signatures
+// without prefix are checked against every valid key so that rings can
+// replace a plain signing method.
+type KeyRing struct {
+	// Loader is used by Reload and Watch
+	Loader KeyLoader
+
+	// Now returns the current time, time.Now when nil
+	Now func() time.Time
+
+	mu     sync.RWMutex
+	keys   map[string]Key
+	order  []string
+	active string
+}
+
+// NewKeyRing returns a ring of keys signing with active
+func NewKeyRing(keys []Key, active string) (*KeyRing, error) {
+	ring := &KeyRing{}
+	if err := ring.Set(keys, active); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+// LoadKeyRing returns a ring filled and reloaded by loader
+func LoadKeyRing(loader KeyLoader) (*KeyRing, error) {
+	ring := &KeyRing{Loader: loader}
+	if err := ring.Reload(); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+// Set replaces the keys of the ring
+func (ring *KeyRing) Set(keys []Key, active string) error {
+	indexed := make(map[string]Key, len(keys))
+	order := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if key.ID == "" || strings.Contains(key.ID, KeyIDSeparator) || key.Method == nil {
+			return fmt.Errorf("%w: key %q", ErrKeyInvalid, key.ID)
+		}
+		if _, ok := indexed[key.ID]; ok {
+			return fmt.Errorf("%w: duplicate key %q", ErrKeyInvalid, key.ID)
+		}
+		indexed[key.ID] = key
+		order = append(order, key.ID)
+	}
+	if _, ok := indexed[active]; !ok && active != "" {
+		return fmt.Errorf("%w: active key %q", ErrKeyUnknown, active)
+	}
+
+	ring.mu.Lock()
+	ring.keys, ring.order, ring.active = indexed, order, active
+	ring.mu.Unlock()
+	return nil
+}
+
+// Reload replaces the keys with the ones returned by Loader. The ring is left
+// untouched on error.
+func (ring *KeyRing) Reload() error {
+	if ring.Loader == nil {
+		return ErrKeyUnavailable
+	}
+	keys, active, err := ring.Loader()
+	if err != nil {
+		return err
+	}
+	return ring.Set(keys, active)
+}
+
+// Watch reloads the ring every interval until stop is called. Errors are
+// passed to onError, which may be nil.
+func (ring *KeyRing) Watch(interval time.Duration, onError func(error)) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := ring.Reload(); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+func (ring *KeyRing) now() time.Time {
+	if ring.Now != nil {
+		return ring.Now()
+	}
+	return time.Now()
+}
+
+// ActiveKeyID returns the id of the key used by Sign
+func (ring *KeyRing) ActiveKeyID() string {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	return ring.active
+}
+
+// Sign signs with the active key and prefixes the signature with its id
+func (ring *KeyRing) Sign(signingString string) (string, error) {
+	keyID, signature, err := ring.SignWithKeyID(signingString)
+	if err != nil {
+		return "", err
+	}
+	return keyID + KeyIDSeparator + signature, nil
+}
+
+// SignWithKeyID signs with the active key and returns its id separately, for
+// protocols carrying the key id in a header
+func (ring *KeyRing) SignWithKeyID(signingString string) (keyID, signature string, err error) {
+	key, err := ring.ActiveKey()
+	if err != nil {
+		return "", "", err
+	}
+	signature, err = key.Method.Sign(signingString)
+	return key.ID, signature, err
+}
+
+// ActiveKey returns the key used by Sign if it is currently valid
+func (ring *KeyRing) ActiveKey() (Key, error) {
+	ring.mu.RLock()
+	key, ok := ring.keys[ring.active]
+	ring.mu.RUnlock()
+	if !ok {
+		return Key{}, ErrKeyUnavailable
+	}
+	if !key.validAt(ring.now()) {
+		return Key{}, fmt.Errorf("%w: %s", ErrKeyInactive, key.ID)
+	}
+	return key, nil
+}
+
+// Verify checks signature with the key named by its prefix, or with every
+// valid key when it has none
+func (ring *KeyRing) Verify(signingString, signature string) error {
+	now := ring.now()
+	if i := strings.LastIndex(signature, KeyIDSeparator); i >= 0 {
+		ring.mu.RLock()
+		key, ok := ring.keys[signature[:i]]
+		ring.mu.RUnlock()
+		if !ok {
+			return ErrKeyUnknown
+		}
+		if !key.validAt(now) {
+			return fmt.Errorf("%w: %s", ErrKeyInactive, key.ID)
+		}
+		return key.Method.Verify(signingString, signature[i+len(KeyIDSeparator):])
+	}
+
+	ring.mu.RLock()
+	keys := make([]Key, 0, len(ring.order))
+	for _, id := range ring.order {
+		keys = append(keys, ring.keys[id])
+	}
+	ring.mu.RUnlock()
+	for _, key := range keys {
+		if key.validAt(now) && key.Method.Verify(signingString, signature) == nil {
+			return nil
+		}
+	}
+	return ErrSignatureInvalid
+}
+
+// KeyIDFromHeader wraps getSignature for rings whose key id travels in a
+// header: the value of header is prefixed to the signature
+func KeyIDFromHeader(header string, getSignature func(r *http.Request) (string, error)) func(r *http.Request) (string, error) {
+	return func(r *http.Request) (string, error) {
+		signature, err := getSignature(r)
+		if err != nil {
+			return "", err
+		}
+		keyID := r.Header.Get(header)
+		if keyID == "" {
+			return signature, nil
+		}
+		return keyID + KeyIDSeparator + signature, nil
+	}
+}
+
+// keyFile is the format read by LoadKeyFile
+type keyFile struct {
+	Active string `json:"active"`
+	Keys   []struct {
+		JWK
+		NotBefore time.Time `json:"not_before"`
+		NotAfter  time.Time `json:"not_after"`
+	} `json:"keys"`
+}
+
+// LoadKeyFile returns a loader reading path on every call. The file holds the
+// active key id and JWKs with optional RFC 3339 validity bounds:
+//
+//	{
+//		"active": "2020-06",
+//		"keys": [
+//			{"kid": "2020-05", "kty": "oct", "alg": "HS256", "k": "...", "not_after": "2020-06-07T00:00:00Z"},
+//			{"kid": "2020-06", "kty": "oct", "alg": "HS256", "k": "..."}
+//		]
+//	}
+func LoadKeyFile(path string) KeyLoader {
+	return func() ([]Key, string, error) {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, "", err
+		}
+		var file keyFile
+		if err := json.Unmarshal(content, &file); err != nil {
+			return nil, "", err
+		}
+
+		keys := make([]Key, 0, len(file.Keys))
+		for _, entry := range file.Keys {
+			method, err := entry.JWK.SigningMethod()
+			if err != nil {
+				return nil, "", fmt.Errorf("key %q: %w", entry.Kid, err)
+			}
+			keys = append(keys, Key{ID: entry.Kid, Method: method, NotBefore: entry.NotBefore, NotAfter: entry.NotAfter})
+		}
+		return keys, file.Active, nil
+	}
+}
+
+var (
+	_ SigningMethod = (*KeyRing)(nil)
+)
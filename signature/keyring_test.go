@@ -0,0 +1,157 @@
+package signature
+
+import (
+	"crypto"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func hmacKey(id, secret string) Key {
+	return Key{ID: id, Method: SigningMethodHMAC{Key: []byte(secret), HashMethod: crypto.SHA256}}
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	old := hmacKey("old", "first secret")
+	old.NotAfter = now.Add(time.Hour)
+	keys := []Key{old, hmacKey("new", "second secret")}
+	ring, err := NewKeyRing(keys, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring.Now = func() time.Time { return now }
+
+	oldSign, err := ring.Sign("message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.Set(keys, "new"); err != nil {
+		t.Fatal(err)
+	}
+	newSign, err := ring.Sign("message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldSign[:4] != "old." || newSign[:4] != "new." {
+		t.Fatalf("signatures %q and %q lack their key id", oldSign, newSign)
+	}
+	for _, sign := range []string{oldSign, newSign} {
+		if err := ring.Verify("message", sign); err != nil {
+			t.Errorf("%s rejected: %v", sign, err)
+		}
+		if err := ring.Verify("tampered", sign); err != ErrSignatureInvalid {
+			t.Errorf("%s over a tampered message: %v", sign, err)
+		}
+	}
+
+	// an unprefixed signature is checked against every valid key
+	plain, _ := old.Method.Sign("message")
+	if err := ring.Verify("message", plain); err != nil {
+		t.Errorf("unprefixed signature rejected: %v", err)
+	}
+
+	// a signature must not be accepted under another key id
+	if err := ring.Verify("message", "new."+plain); err != ErrSignatureInvalid {
+		t.Errorf("signature relabelled with another key: %v", err)
+	}
+	if err := ring.Verify("message", "gone."+plain); err != ErrKeyUnknown {
+		t.Errorf("unknown key: %v", err)
+	}
+
+	// once the old key expires its signatures are rejected
+	now = now.Add(2 * time.Hour)
+	if err := ring.Verify("message", oldSign); !errors.Is(err, ErrKeyInactive) {
+		t.Errorf("signature of an expired key: %v", err)
+	}
+	if err := ring.Verify("message", plain); err != ErrSignatureInvalid {
+		t.Errorf("unprefixed signature of an expired key: %v", err)
+	}
+	if err := ring.Verify("message", newSign); err != nil {
+		t.Errorf("active key rejected after rotation: %v", err)
+	}
+}
+
+func TestKeyRingSetRejectsInvalidKeys(t *testing.T) {
+	cases := map[string]struct {
+		keys   []Key
+		active string
+		err    error
+	}{
+		"empty id":     {[]Key{hmacKey("", "secret")}, "", ErrKeyInvalid},
+		"separator":    {[]Key{hmacKey("a.b", "secret")}, "", ErrKeyInvalid},
+		"duplicate":    {[]Key{hmacKey("a", "secret"), hmacKey("a", "other")}, "a", ErrKeyInvalid},
+		"no method":    {[]Key{{ID: "a"}}, "", ErrKeyInvalid},
+		"unknown head": {[]Key{hmacKey("a", "secret")}, "b", ErrKeyUnknown},
+	}
+	for name, c := range cases {
+		if _, err := NewKeyRing(c.keys, c.active); !errors.Is(err, c.err) {
+			t.Errorf("%s: %v, want %v", name, err, c.err)
+		}
+	}
+
+	now := time.Unix(1700000000, 0)
+	future := hmacKey("future", "secret")
+	future.NotBefore = now.Add(time.Hour)
+	ring, err := NewKeyRing([]Key{future}, "future")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring.Now = func() time.Time { return now }
+	if _, err := ring.Sign("message"); !errors.Is(err, ErrKeyInactive) {
+		t.Errorf("signing with a key not yet valid: %v", err)
+	}
+}
+
+func TestLoadKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.json")
+
+	k := func(secret string) string { return base64.RawURLEncoding.EncodeToString([]byte(secret)) }
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`{"active": "a", "keys": [{"kid": "a", "kty": "oct", "alg": "HS256", "k": "` + k("first") + `"}]}`)
+
+	ring, err := LoadKeyRing(LoadKeyFile(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign, err := ring.Sign("message")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write(`{"active": "b", "keys": [
+		{"kid": "a", "kty": "oct", "alg": "HS256", "k": "` + k("first") + `", "not_after": "2999-01-01T00:00:00Z"},
+		{"kid": "b", "kty": "oct", "alg": "HS256", "k": "` + k("second") + `"}
+	]}`)
+	if err := ring.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if ring.ActiveKeyID() != "b" {
+		t.Errorf("active key %q after reload", ring.ActiveKeyID())
+	}
+	if err := ring.Verify("message", sign); err != nil {
+		t.Errorf("signature of the rotated key rejected: %v", err)
+	}
+
+	// a broken file leaves the ring as it was
+	write(`{"active": "c", "keys": [{"kid": "c", "kty": "EC", "crv": "P-192"}]}`)
+	if err := ring.Reload(); !errors.Is(err, ErrKeyUnsupported) {
+		t.Errorf("reloading a broken file: %v", err)
+	}
+	if ring.ActiveKeyID() != "b" {
+		t.Errorf("active key %q after a failed reload", ring.ActiveKeyID())
+	}
+}
@@ -0,0 +1,283 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // hashes selected by JWK alg
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+
+	"github.com/go-errors/errors"
+)
+
+var (
+	ErrKeyInvalid     = errors.New("key is invalid")
+	ErrKeyUnsupported = errors.New("key type is unsupported")
+)
+
+// ParsePrivateKeyPEM parses a PKCS#1, SEC 1 or PKCS#8 private key
+func ParsePrivateKeyPEM(data []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrKeyInvalid
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	return nil, fmt.Errorf("%w: PEM block %s", ErrKeyUnsupported, block.Type)
+}
+
+// ParsePublicKeyPEM parses a PKIX or PKCS#1 public key, or the key of a
+// certificate
+func ParsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrKeyInvalid
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	return nil, fmt.Errorf("%w: PEM block %s", ErrKeyUnsupported, block.Type)
+}
+
+// NewSigningMethod returns the signing method matching an RSA, ECDSA or
+// Ed25519 key. Public keys give verify-only methods. RSA keys use PKCS#1
+// v1.5, build a SigningMethodRSA directly for PSS. hash is ignored for
+// Ed25519.
+func NewSigningMethod(key interface{}, hash crypto.Hash) (SigningMethod, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return SigningMethodRSA{PrivateKey: k, HashMethod: hash}, nil
+	case *rsa.PublicKey:
+		return SigningMethodRSA{PublicKey: k, HashMethod: hash}, nil
+	case *ecdsa.PrivateKey:
+		return SigningMethodECDSA{PrivateKey: k, HashMethod: hash}, nil
+	case *ecdsa.PublicKey:
+		return SigningMethodECDSA{PublicKey: k, HashMethod: hash}, nil
+	case ed25519.PrivateKey:
+		return SigningMethodEd25519{PrivateKey: k}, nil
+	case ed25519.PublicKey:
+		return SigningMethodEd25519{PublicKey: k}, nil
+	}
+	return nil, fmt.Errorf("%w: %T", ErrKeyUnsupported, key)
+}
+
+// JWK is a JSON Web Key as described in RFC 7517 and RFC 8037
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+
+	// symmetric
+	K string `json:"k,omitempty"`
+
+	// private part, RSA keys also need the primes P and Q
+	D string `json:"d,omitempty"`
+	P string `json:"p,omitempty"`
+	Q string `json:"q,omitempty"`
+}
+
+// ParseJWK parses a single JSON Web Key
+func ParseJWK(data []byte) (*JWK, error) {
+	var key JWK
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, ErrKeyInvalid
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func jwkCurve(crv string) (elliptic.Curve, error) {
+	switch crv {
+	case "P-256":
+		return elliptic.P256(), nil
+	case "P-384":
+		return elliptic.P384(), nil
+	case "P-521":
+		return elliptic.P521(), nil
+	}
+	return nil, fmt.Errorf("%w: curve %s", ErrKeyUnsupported, crv)
+}
+
+// PublicKey returns the public part of the key
+func (key JWK) PublicKey() (crypto.PublicKey, error) {
+	switch key.Kty {
+	case "RSA":
+		n, err := decodeBigInt(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(key.E)
+		if err != nil || !e.IsInt64() {
+			return nil, ErrKeyInvalid
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curve, err := jwkCurve(key.Crv)
+		if err != nil {
+			return nil, err
+		}
+		x, err := decodeBigInt(key.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(key.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, ErrKeyInvalid
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if key.Crv != "Ed25519" {
+			return nil, fmt.Errorf("%w: curve %s", ErrKeyUnsupported, key.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(key.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, ErrKeyInvalid
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("%w: kty %s", ErrKeyUnsupported, key.Kty)
+}
+
+// PrivateKey returns the private key, or ErrKeyUnavailable for public JWKs
+func (key JWK) PrivateKey() (crypto.PrivateKey, error) {
+	if key.D == "" {
+		return nil, ErrKeyUnavailable
+	}
+	public, err := key.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		d, err := decodeBigInt(key.D)
+		if err != nil {
+			return nil, err
+		}
+		p, err := decodeBigInt(key.P)
+		if err != nil {
+			return nil, err
+		}
+		q, err := decodeBigInt(key.Q)
+		if err != nil {
+			return nil, err
+		}
+		private := &rsa.PrivateKey{PublicKey: *pub, D: d, Primes: []*big.Int{p, q}}
+		if err := private.Validate(); err != nil {
+			return nil, ErrKeyInvalid
+		}
+		private.Precompute()
+		return private, nil
+	case *ecdsa.PublicKey:
+		d, err := decodeBigInt(key.D)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PrivateKey{PublicKey: *pub, D: d}, nil
+	case ed25519.PublicKey:
+		seed, err := base64.RawURLEncoding.DecodeString(key.D)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, ErrKeyInvalid
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	return nil, ErrKeyUnsupported
+}
+
+// SigningMethod returns the method described by the key and its alg. Keys
+// without a private part give verify-only methods, oct keys give HMAC.
+func (key JWK) SigningMethod() (SigningMethod, error) {
+	if key.Kty == "oct" {
+		secret, err := base64.RawURLEncoding.DecodeString(key.K)
+		if err != nil || len(secret) == 0 {
+			return nil, ErrKeyInvalid
+		}
+		hash := crypto.SHA256
+		switch key.Alg {
+		case "HS384":
+			hash = crypto.SHA384
+		case "HS512":
+			hash = crypto.SHA512
+		}
+		return SigningMethodHMAC{Key: secret, HashMethod: hash}, nil
+	}
+
+	var material interface{}
+	var err error
+	if key.D != "" {
+		material, err = key.PrivateKey()
+	} else {
+		material, err = key.PublicKey()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hash := crypto.SHA256
+	switch key.Alg {
+	case "RS384", "PS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "PS512", "ES512":
+		hash = crypto.SHA512
+	case "":
+		switch key.Crv {
+		case "P-384":
+			hash = crypto.SHA384
+		case "P-521":
+			hash = crypto.SHA512
+		}
+	}
+
+	method, err := NewSigningMethod(material, hash)
+	if err != nil {
+		return nil, err
+	}
+	if rsaMethod, ok := method.(SigningMethodRSA); ok && len(key.Alg) > 0 && key.Alg[0] == 'P' {
+		rsaMethod.PSS = true
+		return rsaMethod, nil
+	}
+	return method, nil
+}
@@ -0,0 +1,193 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// flipFirst changes the first character of an encoded signature
+func flipFirst(sign string) string {
+	if sign[0] == 'A' {
+		return "B" + sign[1:]
+	}
+	return "A" + sign[1:]
+}
+
+// flipPaddingBit changes the lowest bit of the last base64 character, which
+// only carries padding for the signature sizes used here. Lenient decoding
+// would return the same bytes.
+func flipPaddingBit(sign string) string {
+	end := len(strings.TrimRight(sign, "="))
+	for _, alphabet := range []string{
+		"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_",
+		"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/",
+	} {
+		if i := strings.IndexByte(alphabet, sign[end-1]); i >= 0 {
+			return sign[:end-1] + string(alphabet[i^1]) + sign[end:]
+		}
+	}
+	return sign
+}
+
+func assertSignsAndVerifies(t *testing.T, name string, signer, verifier SigningMethod) {
+	t.Helper()
+	sign, err := signer.Sign("message")
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if err := verifier.Verify("message", sign); err != nil {
+		t.Errorf("%s: signature rejected: %v", name, err)
+	}
+	if err := verifier.Verify("tampered", sign); err == nil {
+		t.Errorf("%s: tampered message accepted", name)
+	}
+	if err := verifier.Verify("message", flipFirst(sign)); err == nil {
+		t.Errorf("%s: tampered signature accepted", name)
+	}
+	if err := verifier.Verify("message", flipPaddingBit(sign)); err == nil {
+		t.Errorf("%s: signature with padding bits set accepted", name)
+	}
+}
+
+func TestSigningMethods(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertSignsAndVerifies(t, "rsa",
+		SigningMethodRSA{PrivateKey: rsaKey, HashMethod: crypto.SHA256},
+		SigningMethodRSA{PublicKey: &rsaKey.PublicKey, HashMethod: crypto.SHA256})
+	assertSignsAndVerifies(t, "rsa-pss",
+		SigningMethodRSA{PrivateKey: rsaKey, HashMethod: crypto.SHA256, PSS: true},
+		SigningMethodRSA{PublicKey: &rsaKey.PublicKey, HashMethod: crypto.SHA256, PSS: true})
+	assertSignsAndVerifies(t, "ecdsa",
+		SigningMethodECDSA{PrivateKey: ecKey, HashMethod: crypto.SHA256},
+		SigningMethodECDSA{PublicKey: &ecKey.PublicKey, HashMethod: crypto.SHA256})
+	assertSignsAndVerifies(t, "ed25519",
+		SigningMethodEd25519{PrivateKey: edPrivate},
+		SigningMethodEd25519{PublicKey: edPublic})
+
+	// a PKCS#1 v1.5 signature is not a PSS one
+	sign, _ := SigningMethodRSA{PrivateKey: rsaKey, HashMethod: crypto.SHA256}.Sign("message")
+	if err := (SigningMethodRSA{PublicKey: &rsaKey.PublicKey, HashMethod: crypto.SHA256, PSS: true}).Verify("message", sign); err == nil {
+		t.Error("PKCS#1 v1.5 signature accepted as PSS")
+	}
+
+	// verify-only methods cannot sign
+	if _, err := (SigningMethodEd25519{PublicKey: edPublic}).Sign("message"); err != ErrKeyUnavailable {
+		t.Errorf("signing with a public key: %v", err)
+	}
+}
+
+func TestParsePEM(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err = x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := ParsePublicKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := NewSigningMethod(private, crypto.SHA384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := NewSigningMethod(public, crypto.SHA384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSignsAndVerifies(t, "pem", signer, verifier)
+
+	if _, err := ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "DSA PRIVATE KEY", Bytes: der})); !errors.Is(err, ErrKeyUnsupported) {
+		t.Errorf("unsupported PEM block: %v", err)
+	}
+	if _, err := ParsePublicKeyPEM([]byte("not a key")); err != ErrKeyInvalid {
+		t.Errorf("garbage: %v", err)
+	}
+	if _, err := NewSigningMethod("secret", crypto.SHA256); !errors.Is(err, ErrKeyUnsupported) {
+		t.Errorf("unsupported key: %v", err)
+	}
+}
+
+func TestJWK(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	public := JWK{Kty: "EC", Crv: "P-256", Alg: "ES256", X: encode(ecKey.X.Bytes()), Y: encode(ecKey.Y.Bytes())}
+	private := public
+	private.D = encode(ecKey.D.Bytes())
+
+	content, _ := json.Marshal(private)
+	parsed, err := ParseJWK(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := parsed.SigningMethod()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := public.SigningMethod()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertSignsAndVerifies(t, "jwk", signer, verifier)
+
+	if _, err := public.PrivateKey(); err != ErrKeyUnavailable {
+		t.Errorf("private key of a public JWK: %v", err)
+	}
+
+	offCurve := public
+	offCurve.Y = encode(ecKey.X.Bytes())
+	if _, err := offCurve.PublicKey(); err != ErrKeyInvalid {
+		t.Errorf("point off the curve: %v", err)
+	}
+	for _, key := range []JWK{{Kty: "EC", Crv: "P-192"}, {Kty: "OKP", Crv: "X25519"}, {Kty: "DSA"}} {
+		if _, err := key.PublicKey(); !errors.Is(err, ErrKeyUnsupported) {
+			t.Errorf("%s %s: %v", key.Kty, key.Crv, err)
+		}
+	}
+
+	hmacKey := JWK{Kty: "oct", Alg: "HS512", K: encode([]byte("secret"))}
+	method, err := hmacKey.SigningMethod()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hmac, ok := method.(SigningMethodHMAC); !ok || hmac.HashMethod != crypto.SHA512 {
+		t.Errorf("oct key gave %#v", method)
+	}
+}
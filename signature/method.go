@@ -4,3 +4,11 @@ type SigningMethod interface {
 	Verify(signingString, signature string) error // Returns nil if signature is valid
 	Sign(signingString string) (string, error)    // Returns encoded signature or error
 }
+
+// ByteSigningMethod is implemented by methods whose signatures are plain
+// byte sequences, for protocols that carry them in their own encoding.
+// Prefix and Encoding of SigningMethodHMAC don't apply to these bytes.
+type ByteSigningMethod interface {
+	VerifyBytes(signingString string, signature []byte) error
+	SignBytes(signingString string) ([]byte, error)
+}
@@ -0,0 +1,105 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+)
+
+// SigningMethodRSA signs with RSASSA-PKCS1-v1_5, or RSASSA-PSS when PSS is
+// set. A method holding only PublicKey can verify but not sign.
+type SigningMethodRSA struct {
+	PrivateKey *rsa.PrivateKey
+	PublicKey  *rsa.PublicKey
+	HashMethod crypto.Hash
+	PSS        bool
+}
+
+func (method SigningMethodRSA) publicKey() *rsa.PublicKey {
+	if method.PublicKey != nil {
+		return method.PublicKey
+	}
+	if method.PrivateKey != nil {
+		return &method.PrivateKey.PublicKey
+	}
+	return nil
+}
+
+func (method SigningMethodRSA) digest(signingString string) ([]byte, error) {
+	if !method.HashMethod.Available() {
+		return nil, ErrHashUnavailable
+	}
+	hasher := method.HashMethod.New()
+	hasher.Write([]byte(signingString))
+	return hasher.Sum(nil), nil
+}
+
+func (method SigningMethodRSA) Verify(signingString, signature string) error {
+	key := method.publicKey()
+	if key == nil {
+		return ErrKeyUnavailable
+	}
+	sig, err := rawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	return method.VerifyBytes(signingString, sig)
+}
+
+// VerifyBytes implements ByteSigningMethod
+func (method SigningMethodRSA) VerifyBytes(signingString string, sig []byte) error {
+	key := method.publicKey()
+	if key == nil {
+		return ErrKeyUnavailable
+	}
+	digest, err := method.digest(signingString)
+	if err != nil {
+		return err
+	}
+
+	if method.PSS {
+		err = rsa.VerifyPSS(key, method.HashMethod, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto})
+	} else {
+		err = rsa.VerifyPKCS1v15(key, method.HashMethod, digest, sig)
+	}
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+func (method SigningMethodRSA) Sign(signingString string) (string, error) {
+	sig, err := method.SignBytes(signingString)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// SignBytes implements ByteSigningMethod
+func (method SigningMethodRSA) SignBytes(signingString string) ([]byte, error) {
+	if method.PrivateKey == nil {
+		return nil, ErrKeyUnavailable
+	}
+	digest, err := method.digest(signingString)
+	if err != nil {
+		return nil, err
+	}
+
+	var sig []byte
+	if method.PSS {
+		sig, err = rsa.SignPSS(rand.Reader, method.PrivateKey, method.HashMethod, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	} else {
+		sig, err = rsa.SignPKCS1v15(rand.Reader, method.PrivateKey, method.HashMethod, digest)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+var (
+	_ SigningMethod     = SigningMethodRSA{}
+	_ ByteSigningMethod = SigningMethodRSA{}
+)
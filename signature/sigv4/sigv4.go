@@ -0,0 +1,488 @@
+/*
+Package sigv4 signs and verifies requests with the AWS Signature Version 4
+scheme: a canonical request is hashed into a string to sign, which is signed
+with HMAC-SHA256 under a key derived from the secret and the credential scope
+(date, region and service).
+
+Verifier is a signature.SigningMethod whose SigningString and Signature
+methods fit CheckSignature, for both the Authorization header and presigned
+URLs:
+
+	verifier := sigv4.Verifier{Credentials: sigv4.StaticCredentials{"AKID": "secret"}, Region: "us-east-1", Service: "orders"}
+	router.Use(middlewares.CheckSignature(verifier, verifier.SigningString, verifier.Signature))
+
+The signing string handed from SigningString to Verify is the credential
+followed by a newline and the string to sign, since the key depends on both.
+*/
+package sigv4
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/jeffguorg/middlewares/signature"
+)
+
+const (
+	Algorithm       = "AWS4-HMAC-SHA256"
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+	TimeFormat      = "20060102T150405Z"
+
+	terminator = "aws4_request"
+	dateFormat = "20060102"
+
+	// MaxExpires is the longest validity accepted for presigned URLs
+	MaxExpires = 7 * 24 * time.Hour
+
+	defaultMaxPayloadSize = 10 << 20
+)
+
+var (
+	ErrAuthorizationMissing   = errors.New("request is not signed")
+	ErrAuthorizationMalformed = errors.New("authorization is malformed")
+	ErrCredentialScope        = errors.New("credential scope is invalid")
+	ErrCredentialNotFound     = errors.New("access key is not found")
+	ErrRequestTimeSkewed      = errors.New("request time is too far from the server time")
+	ErrRequestExpired         = errors.New("presigned request has expired")
+	ErrPayloadHashMismatch    = errors.New("payload does not match its hash")
+	ErrPayloadTooLarge        = errors.New("payload is too large to be hashed")
+)
+
+// CredentialStore resolves access key ids to their secret
+type CredentialStore interface {
+	LookupSecret(accessKeyID string) (string, error)
+}
+
+// CredentialStoreFunc adapts a function to CredentialStore
+type CredentialStoreFunc func(accessKeyID string) (string, error)
+
+// LookupSecret calls f(accessKeyID)
+func (f CredentialStoreFunc) LookupSecret(accessKeyID string) (string, error) {
+	return f(accessKeyID)
+}
+
+// StaticCredentials maps access key ids to secrets
+type StaticCredentials map[string]string
+
+// LookupSecret implements CredentialStore
+func (credentials StaticCredentials) LookupSecret(accessKeyID string) (string, error) {
+	if secret, ok := credentials[accessKeyID]; ok {
+		return secret, nil
+	}
+	return "", ErrCredentialNotFound
+}
+
+// Verifier checks requests signed with the Authorization header or with
+// presigned query parameters
+type Verifier struct {
+	Credentials CredentialStore
+
+	// Region and Service are required in the credential scope when set
+	Region  string
+	Service string
+
+	// MaxSkew bounds the distance between X-Amz-Date and the server time
+	// for header signatures, 15 minutes when 0
+	MaxSkew time.Duration
+
+	// MaxPayloadSize bounds the body read to check X-Amz-Content-Sha256,
+	// 10 MiB when 0. Bodies that can be read again through r.GetBody, such
+	// as the ones kept by StoreBodyInContext, are hashed as a stream
+	// instead and bounded by whoever stored them.
+	MaxPayloadSize int64
+
+	// Now returns the current time, time.Now when nil
+	Now func() time.Time
+}
+
+type authorization struct {
+	credential    string
+	signedHeaders []string
+	signature     string
+	amzDate       string
+	expires       string
+}
+
+func parseAuthorization(r *http.Request) (authorization, error) {
+	query := r.URL.Query()
+	if query.Get("X-Amz-Signature") != "" {
+		if query.Get("X-Amz-Algorithm") != Algorithm {
+			return authorization{}, fmt.Errorf("%w: unsupported algorithm", ErrAuthorizationMalformed)
+		}
+		return authorization{
+			credential:    query.Get("X-Amz-Credential"),
+			signedHeaders: strings.Split(query.Get("X-Amz-SignedHeaders"), ";"),
+			signature:     query.Get("X-Amz-Signature"),
+			amzDate:       query.Get("X-Amz-Date"),
+			expires:       query.Get("X-Amz-Expires"),
+		}, nil
+	}
+
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return authorization{}, ErrAuthorizationMissing
+	}
+	if !strings.HasPrefix(header, Algorithm+" ") {
+		return authorization{}, fmt.Errorf("%w: unsupported algorithm", ErrAuthorizationMalformed)
+	}
+	auth := authorization{amzDate: r.Header.Get("X-Amz-Date")}
+	for _, field := range strings.Split(header[len(Algorithm)+1:], ",") {
+		parts := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(parts) != 2 {
+			return authorization{}, ErrAuthorizationMalformed
+		}
+		switch parts[0] {
+		case "Credential":
+			auth.credential = parts[1]
+		case "SignedHeaders":
+			auth.signedHeaders = strings.Split(parts[1], ";")
+		case "Signature":
+			auth.signature = parts[1]
+		}
+	}
+	if auth.credential == "" || auth.signature == "" || len(auth.signedHeaders) == 0 {
+		return authorization{}, ErrAuthorizationMalformed
+	}
+	return auth, nil
+}
+
+// Signature returns the signature of r, from the Authorization header or
+// the X-Amz-Signature query parameter
+func (v Verifier) Signature(r *http.Request) (string, error) {
+	auth, err := parseAuthorization(r)
+	if err != nil {
+		return "", err
+	}
+	return auth.signature, nil
+}
+
+// SigningString checks the credential scope and the request time of r and
+// returns the credential and string to sign for Verify
+func (v Verifier) SigningString(r *http.Request) (string, error) {
+	auth, err := parseAuthorization(r)
+	if err != nil {
+		return "", err
+	}
+
+	scope := strings.Split(auth.credential, "/")
+	if len(scope) != 5 || scope[4] != terminator {
+		return "", ErrCredentialScope
+	}
+	if (v.Region != "" && scope[2] != v.Region) || (v.Service != "" && scope[3] != v.Service) {
+		return "", ErrCredentialScope
+	}
+
+	signedAt, err := time.Parse(TimeFormat, auth.amzDate)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid X-Amz-Date", ErrAuthorizationMalformed)
+	}
+	if signedAt.Format(dateFormat) != scope[1] {
+		return "", ErrCredentialScope
+	}
+	if err := v.checkTime(signedAt, auth.expires); err != nil {
+		return "", err
+	}
+
+	if !containsString(auth.signedHeaders, "host") {
+		return "", fmt.Errorf("%w: host must be signed", ErrAuthorizationMalformed)
+	}
+
+	payloadHash := UnsignedPayload
+	if auth.expires == "" {
+		if payloadHash, err = v.verifyPayload(r); err != nil {
+			return "", err
+		}
+	}
+
+	canonical := CanonicalRequest(r, auth.signedHeaders, payloadHash)
+	return auth.credential + "\n" + StringToSign(auth.amzDate, strings.Join(scope[1:], "/"), canonical), nil
+}
+
+func (v Verifier) checkTime(signedAt time.Time, expires string) error {
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+
+	if expires != "" {
+		seconds, err := strconv.Atoi(expires)
+		if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > MaxExpires {
+			return fmt.Errorf("%w: invalid X-Amz-Expires", ErrAuthorizationMalformed)
+		}
+		if signedAt.After(now.Add(time.Minute)) {
+			return ErrRequestTimeSkewed
+		}
+		if now.After(signedAt.Add(time.Duration(seconds) * time.Second)) {
+			return ErrRequestExpired
+		}
+		return nil
+	}
+
+	maxSkew := v.MaxSkew
+	if maxSkew == 0 {
+		maxSkew = 15 * time.Minute
+	}
+	if skew := now.Sub(signedAt); skew > maxSkew || skew < -maxSkew {
+		return ErrRequestTimeSkewed
+	}
+	return nil
+}
+
+// verifyPayload returns the payload hash to canonicalize. A declared
+// X-Amz-Content-Sha256 is checked against the body unless it is
+// UNSIGNED-PAYLOAD.
+func (v Verifier) verifyPayload(r *http.Request) (string, error) {
+	declared := r.Header.Get("X-Amz-Content-Sha256")
+	if declared == UnsignedPayload {
+		return declared, nil
+	}
+
+	actual, err := v.payloadHash(r)
+	if err != nil {
+		return "", err
+	}
+	if declared != "" && !strings.EqualFold(declared, actual) {
+		return "", ErrPayloadHashMismatch
+	}
+	return actual, nil
+}
+
+// payloadHash hashes the body of r, leaving it readable for the handler
+func (v Verifier) payloadHash(r *http.Request) (string, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return hashHex(nil), nil
+	}
+
+	if r.GetBody != nil {
+		body, err := r.GetBody()
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+		hasher := sha256.New()
+		if _, err := io.Copy(hasher, body); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(hasher.Sum(nil)), nil
+	}
+
+	limit := v.MaxPayloadSize
+	if limit == 0 {
+		limit = defaultMaxPayloadSize
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	r.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	if int64(len(body)) > limit {
+		return "", ErrPayloadTooLarge
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return hashHex(body), nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks signature against a signing string produced by SigningString
+func (v Verifier) Verify(signingString, sig string) error {
+	expected, err := v.Sign(signingString)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(sig))) {
+		return signature.ErrSignatureInvalid
+	}
+	return nil
+}
+
+// Sign signs a signing string produced by SigningString with the secret of
+// its access key
+func (v Verifier) Sign(signingString string) (string, error) {
+	parts := strings.SplitN(signingString, "\n", 2)
+	if len(parts) != 2 {
+		return "", ErrAuthorizationMalformed
+	}
+	scope := strings.Split(parts[0], "/")
+	if len(scope) != 5 {
+		return "", ErrCredentialScope
+	}
+	if v.Credentials == nil {
+		return "", ErrCredentialNotFound
+	}
+	secret, err := v.Credentials.LookupSecret(scope[0])
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hmacSHA256(SigningKey(secret, scope[1], scope[2], scope[3]), parts[1])), nil
+}
+
+// Signer signs outgoing requests
+type Signer struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	Region          string
+	Service         string
+
+	// UnsignedPayload skips hashing the body
+	UnsignedPayload bool
+
+	// Now returns the current time, time.Now when nil
+	Now func() time.Time
+}
+
+func (s Signer) now() time.Time {
+	if s.Now != nil {
+		return s.Now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+func (s Signer) scope(t time.Time) string {
+	return t.Format(dateFormat) + "/" + s.Region + "/" + s.Service + "/" + terminator
+}
+
+func (s Signer) signature(t time.Time, stringToSign string) string {
+	key := SigningKey(s.SecretAccessKey, t.Format(dateFormat), s.Region, s.Service)
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// signedHeaders returns host, content-type and the x-amz-* headers of r
+func signedHeaders(r *http.Request) []string {
+	names := []string{"host"}
+	for name := range r.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			names = append(names, lower)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Sign adds X-Amz-Date, X-Amz-Content-Sha256 and Authorization headers to r
+func (s Signer) Sign(r *http.Request) error {
+	t := s.now()
+	payloadHash := UnsignedPayload
+	if !s.UnsignedPayload {
+		body, err := readRequestBody(r)
+		if err != nil {
+			return err
+		}
+		payloadHash = hashHex(body)
+	}
+
+	amzDate := t.Format(TimeFormat)
+	r.Header.Set("X-Amz-Date", amzDate)
+	r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	r.Header.Del("Authorization")
+
+	headers := signedHeaders(r)
+	scope := s.scope(t)
+	stringToSign := StringToSign(amzDate, scope, CanonicalRequest(r, headers, payloadHash))
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		Algorithm, s.AccessKeyID, scope, strings.Join(headers, ";"), s.signature(t, stringToSign)))
+	return nil
+}
+
+// Presign returns the URL of r with query parameters allowing it to be
+// performed without credentials for expires. Only the host header is signed
+// and the payload is left unsigned.
+func (s Signer) Presign(r *http.Request, expires time.Duration) (*url.URL, error) {
+	if expires <= 0 || expires > MaxExpires {
+		return nil, fmt.Errorf("%w: invalid expiration", ErrAuthorizationMalformed)
+	}
+	t := s.now()
+	amzDate := t.Format(TimeFormat)
+	scope := s.scope(t)
+
+	u := *r.URL
+	query := u.Query()
+	query.Del("X-Amz-Signature")
+	query.Set("X-Amz-Algorithm", Algorithm)
+	query.Set("X-Amz-Credential", s.AccessKeyID+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires/time.Second)))
+	query.Set("X-Amz-SignedHeaders", "host")
+	u.RawQuery = query.Encode()
+
+	presigned := *r
+	presigned.URL = &u
+	stringToSign := StringToSign(amzDate, scope, CanonicalRequest(&presigned, []string{"host"}, UnsignedPayload))
+	query.Set("X-Amz-Signature", s.signature(t, stringToSign))
+	u.RawQuery = query.Encode()
+	if u.Host == "" {
+		u.Host = r.Host
+	}
+	return &u, nil
+}
+
+// readRequestBody reads the body of an outgoing request without consuming it
+func readRequestBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	if r.GetBody != nil {
+		body, err := r.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return ioutil.ReadAll(body)
+	}
+	content, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(content))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(content)), nil
+	}
+	return content, nil
+}
+
+// Transport is an http.RoundTripper signing every request with Signer
+type Transport struct {
+	Signer Signer
+
+	// Base performs the requests, http.DefaultTransport when nil
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	// a RoundTripper must not modify the request it is given
+	signed := r.Clone(r.Context())
+	if err := t.Signer.Sign(signed); err != nil {
+		return nil, err
+	}
+	return base.RoundTrip(signed)
+}
+
+var (
+	_ signature.SigningMethod = Verifier{}
+)
@@ -0,0 +1,194 @@
+package sigv4
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeffguorg/middlewares/signature"
+)
+
+// credentials of the AWS Signature Version 4 test suite
+const (
+	testAccessKeyID = "AKIDEXAMPLE"
+	testSecret      = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+func at(t time.Time) func() time.Time {
+	return func() time.Time { return t }
+}
+
+func verify(v Verifier, r *http.Request) error {
+	signingString, err := v.SigningString(r)
+	if err != nil {
+		return err
+	}
+	sig, err := v.Signature(r)
+	if err != nil {
+		return err
+	}
+	return v.Verify(signingString, sig)
+}
+
+func TestSigningKey(t *testing.T) {
+	// from "Examples of how to derive a signing key" of the AWS documentation
+	key := SigningKey(testSecret, "20150830", "us-east-1", "iam")
+	if got, want := hex.EncodeToString(key), "c4afb1cc5771d871763a393e44b703571b55cc28424d1a5e86da6ed3c154a4b9"; got != want {
+		t.Errorf("signing key %s, want %s", got, want)
+	}
+}
+
+func TestVerifierGetVanilla(t *testing.T) {
+	signedAt := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	v := Verifier{Credentials: StaticCredentials{testAccessKeyID: testSecret}, Region: "us-east-1", Service: "service", Now: at(signedAt)}
+	request := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "http://example.amazonaws.com/", nil)
+		r.Header.Set("X-Amz-Date", "20150830T123600Z")
+		r.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31")
+		return r
+	}
+	if err := verify(v, request()); err != nil {
+		t.Fatalf("get-vanilla rejected: %v", err)
+	}
+
+	tampers := map[string]struct {
+		tamper func(*http.Request)
+		err    error
+	}{
+		"method":   {func(r *http.Request) { r.Method = http.MethodDelete }, signature.ErrSignatureInvalid},
+		"path":     {func(r *http.Request) { r.URL.Path = "/admin" }, signature.ErrSignatureInvalid},
+		"query":    {func(r *http.Request) { r.URL.RawQuery = "Param1=value1" }, signature.ErrSignatureInvalid},
+		"host":     {func(r *http.Request) { r.Host = "other.amazonaws.com" }, signature.ErrSignatureInvalid},
+		"date":     {func(r *http.Request) { r.Header.Set("X-Amz-Date", "20150830T123601Z") }, signature.ErrSignatureInvalid},
+		"unsigned": {func(r *http.Request) { r.Header.Del("Authorization") }, ErrAuthorizationMissing},
+		"region": {func(r *http.Request) {
+			r.Header.Set("Authorization", strings.Replace(r.Header.Get("Authorization"), "us-east-1", "eu-west-1", 1))
+		}, ErrCredentialScope},
+		"access key": {func(r *http.Request) {
+			r.Header.Set("Authorization", strings.Replace(r.Header.Get("Authorization"), "AKIDEXAMPLE", "AKIDOTHER", 1))
+		}, ErrCredentialNotFound},
+		"host unsigned": {func(r *http.Request) {
+			r.Header.Set("Authorization", strings.Replace(r.Header.Get("Authorization"), "host;", "", 1))
+		}, ErrAuthorizationMalformed},
+	}
+	for name, c := range tampers {
+		r := request()
+		c.tamper(r)
+		if err := verify(v, r); !errors.Is(err, c.err) {
+			t.Errorf("tampered %s: %v, want %v", name, err, c.err)
+		}
+	}
+
+	v.Now = at(signedAt.Add(20 * time.Minute))
+	if err := verify(v, request()); err != ErrRequestTimeSkewed {
+		t.Errorf("replayed after 20 minutes: %v", err)
+	}
+}
+
+func TestSignerRoundTrip(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	signer := Signer{AccessKeyID: testAccessKeyID, SecretAccessKey: testSecret, Region: "us-east-1", Service: "orders", Now: at(now)}
+	v := Verifier{Credentials: StaticCredentials{testAccessKeyID: testSecret}, Region: "us-east-1", Service: "orders", Now: at(now)}
+
+	body := `{"item": 42}`
+	r := httptest.NewRequest(http.MethodPost, "http://orders.example/orders?b=2&a=1", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if err := signer.Sign(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(v, r); err != nil {
+		t.Fatalf("signed request rejected: %v", err)
+	}
+
+	// the body is covered by X-Amz-Content-Sha256
+	tampered := httptest.NewRequest(http.MethodPost, "http://orders.example/orders?b=2&a=1", strings.NewReader(`{"item": 43}`))
+	tampered.Header = r.Header.Clone()
+	if err := verify(v, tampered); err != ErrPayloadHashMismatch {
+		t.Errorf("tampered body: %v", err)
+	}
+
+	// as are content-type and the signed query
+	tampered = httptest.NewRequest(http.MethodPost, "http://orders.example/orders?b=2&a=1", strings.NewReader(body))
+	tampered.Header = r.Header.Clone()
+	tampered.Header.Set("Content-Type", "text/plain")
+	if err := verify(v, tampered); err != signature.ErrSignatureInvalid {
+		t.Errorf("tampered content-type: %v", err)
+	}
+	tampered = httptest.NewRequest(http.MethodPost, "http://orders.example/orders?b=3&a=1", strings.NewReader(body))
+	tampered.Header = r.Header.Clone()
+	if err := verify(v, tampered); err != signature.ErrSignatureInvalid {
+		t.Errorf("tampered query: %v", err)
+	}
+}
+
+func TestVerifierPayloadLimit(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	signer := Signer{AccessKeyID: testAccessKeyID, SecretAccessKey: testSecret, Region: "us-east-1", Service: "orders", Now: at(now)}
+	v := Verifier{Credentials: StaticCredentials{testAccessKeyID: testSecret}, Region: "us-east-1", Service: "orders", MaxPayloadSize: 8, Now: at(now)}
+
+	request := func(body string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "http://orders.example/orders", strings.NewReader(body))
+		if err := signer.Sign(r); err != nil {
+			t.Fatal(err)
+		}
+		// a server request, whose body cannot be read again
+		r.Body, r.GetBody = ioutil.NopCloser(strings.NewReader(body)), nil
+		return r
+	}
+
+	r := request("12345678")
+	if err := verify(v, r); err != nil {
+		t.Fatalf("body at the limit rejected: %v", err)
+	}
+	if body, _ := ioutil.ReadAll(r.Body); string(body) != "12345678" {
+		t.Errorf("handler reads %q", body)
+	}
+	if err := verify(v, request("123456789")); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("oversized body: %v", err)
+	}
+
+	// a body that can be read again is streamed whatever its size
+	r = request("123456789")
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader("123456789")), nil
+	}
+	if err := verify(v, r); err != nil {
+		t.Errorf("re-readable body rejected: %v", err)
+	}
+}
+
+func TestPresign(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	signer := Signer{AccessKeyID: testAccessKeyID, SecretAccessKey: testSecret, Region: "us-east-1", Service: "s3", Now: at(now)}
+	v := Verifier{Credentials: StaticCredentials{testAccessKeyID: testSecret}, Region: "us-east-1", Service: "s3", Now: at(now.Add(30 * time.Minute))}
+
+	u, err := signer.Presign(httptest.NewRequest(http.MethodGet, "http://files.example/report.pdf", nil), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(v, httptest.NewRequest(http.MethodGet, u.String(), nil)); err != nil {
+		t.Fatalf("presigned URL rejected: %v", err)
+	}
+
+	if err := verify(v, httptest.NewRequest(http.MethodGet, strings.Replace(u.String(), "report.pdf", "secret.pdf", 1), nil)); err != signature.ErrSignatureInvalid {
+		t.Errorf("presigned URL for another object: %v", err)
+	}
+	if err := verify(v, httptest.NewRequest(http.MethodGet, strings.Replace(u.String(), "X-Amz-Expires=3600", "X-Amz-Expires=7200", 1), nil)); err != signature.ErrSignatureInvalid {
+		t.Errorf("presigned URL with a longer expiration: %v", err)
+	}
+
+	v.Now = at(now.Add(2 * time.Hour))
+	if err := verify(v, httptest.NewRequest(http.MethodGet, u.String(), nil)); err != ErrRequestExpired {
+		t.Errorf("expired presigned URL: %v", err)
+	}
+
+	if _, err := signer.Presign(httptest.NewRequest(http.MethodGet, "http://files.example/", nil), 8*24*time.Hour); !errors.Is(err, ErrAuthorizationMalformed) {
+		t.Errorf("presigning beyond MaxExpires: %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package signature
+
+import (
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+)
+
+// Query parameters added to presigned URLs
+const (
+	URLExpiresParam   = "expires"
+	URLKeyIDParam     = "key-id"
+	URLBindParam      = "bind"
+	URLSignatureParam = "signature"
+)
+
+var (
+	ErrURLUnsigned = errors.New("url is not signed")
+	ErrURLExpired  = errors.New("url has expired")
+)
+
+// URLBinding restricts a presigned URL to an HTTP method or a client IP.
+// Empty fields are not bound. When verifying, it holds the method and IP of
+// the request, which are only compared if the URL was bound to them.
+type URLBinding struct {
+	Method   string
+	ClientIP string
+}
+
+// URLSigner creates and checks presigned URLs. The signature covers the
+// canonical path, the sorted query including expires, key-id and bind, and
+// the bound method and IP. The host is not covered so links survive proxies.
+//
+// Paths are compared once decoded and cleaned, so /a/./b, /a//b and
+// /a%2Fb match a URL signed for /a/b. Handlers behind a verified URL should
+// serve the same resource for all of them.
+type URLSigner struct {
+	Method SigningMethod
+
+	// KeyID is sent as key-id and required when verifying. A KeyRing sends
+	// the id of its active key and verifies with the key named in the URL.
+	KeyID string
+}
+
+// Sign returns a copy of u valid until expires
+func (signer URLSigner) Sign(u *url.URL, expires time.Time, binding URLBinding) (*url.URL, error) {
+	query := u.Query()
+	for _, param := range []string{URLExpiresParam, URLKeyIDParam, URLBindParam, URLSignatureParam} {
+		query.Del(param)
+	}
+	query.Set(URLExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+
+	method := signer.Method
+	if ring, ok := method.(*KeyRing); ok {
+		// sign with the active key itself so its id cannot change in between
+		key, err := ring.ActiveKey()
+		if err != nil {
+			return nil, err
+		}
+		method = key.Method
+		query.Set(URLKeyIDParam, key.ID)
+	} else if signer.KeyID != "" {
+		query.Set(URLKeyIDParam, signer.KeyID)
+	}
+
+	var bind []string
+	if binding.Method != "" {
+		bind = append(bind, "method")
+	}
+	if binding.ClientIP != "" {
+		bind = append(bind, "ip")
+	}
+	if len(bind) > 0 {
+		query.Set(URLBindParam, strings.Join(bind, ","))
+	}
+
+	signed := *u
+	sig, err := method.Sign(urlSigningString(&signed, query, binding))
+	if err != nil {
+		return nil, err
+	}
+
+	query.Set(URLSignatureParam, sig)
+	signed.RawQuery = query.Encode()
+	return &signed, nil
+}
+
+// Verify checks the signature and expiration of u against now. binding is
+// compared with the values the URL was bound to.
+func (signer URLSigner) Verify(u *url.URL, binding URLBinding, now time.Time) error {
+	query := u.Query()
+	sig := query.Get(URLSignatureParam)
+	expiresParam := query.Get(URLExpiresParam)
+	if sig == "" || expiresParam == "" {
+		return ErrURLUnsigned
+	}
+	query.Del(URLSignatureParam)
+
+	expires, err := strconv.ParseInt(expiresParam, 10, 64)
+	if err != nil {
+		return ErrURLUnsigned
+	}
+	if now.After(time.Unix(expires, 0)) {
+		return ErrURLExpired
+	}
+
+	// only the parts the URL was bound to are signed
+	bound := URLBinding{}
+	for _, part := range strings.Split(query.Get(URLBindParam), ",") {
+		switch part {
+		case "method":
+			bound.Method = binding.Method
+		case "ip":
+			bound.ClientIP = binding.ClientIP
+		}
+	}
+
+	keyID := query.Get(URLKeyIDParam)
+	if _, isRing := signer.Method.(*KeyRing); isRing {
+		if keyID == "" {
+			return ErrKeyUnknown
+		}
+		sig = keyID + KeyIDSeparator + sig
+	} else if signer.KeyID != "" && keyID != signer.KeyID {
+		return ErrKeyUnknown
+	}
+
+	return signer.Method.Verify(urlSigningString(u, query, bound), sig)
+}
+
+// canonicalPath decodes the path of u, cleans it keeping a trailing slash
+// and escapes it again
+func canonicalPath(u *url.URL) string {
+	decoded := u.Path
+	if decoded == "" {
+		return "/"
+	}
+	cleaned := path.Clean("/" + decoded)
+	if strings.HasSuffix(decoded, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return (&url.URL{Path: cleaned}).EscapedPath()
+}
+
+// urlSigningString joins the canonical path, the sorted query and the bound
+// method and IP with newlines
+func urlSigningString(u *url.URL, query url.Values, binding URLBinding) string {
+	path := canonicalPath(u)
+
+	for _, values := range query {
+		sort.Strings(values)
+	}
+	var b strings.Builder
+	b.WriteString(path)
+	b.WriteByte('\n')
+	b.WriteString(query.Encode())
+	if binding.Method != "" {
+		b.WriteString("\nmethod:")
+		b.WriteString(strings.ToUpper(binding.Method))
+	}
+	if binding.ClientIP != "" {
+		b.WriteString("\nip:")
+		b.WriteString(binding.ClientIP)
+	}
+	return b.String()
+}
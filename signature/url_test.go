@@ -0,0 +1,148 @@
+package signature
+
+import (
+	"crypto"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestURLSigner(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	signer := URLSigner{Method: SigningMethodHMAC{Key: []byte("secret"), HashMethod: crypto.SHA256}, KeyID: "k1"}
+	u, _ := url.Parse("https://files.example/download/report.pdf?user=42")
+	binding := URLBinding{Method: "GET", ClientIP: "203.0.113.7"}
+
+	signed, err := signer.Sign(u, now.Add(time.Minute), binding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.Verify(signed, binding, now); err != nil {
+		t.Fatalf("signed URL rejected: %v", err)
+	}
+
+	// the host is not covered so links survive proxies
+	moved := *signed
+	moved.Host = "internal:8080"
+	if err := signer.Verify(&moved, binding, now); err != nil {
+		t.Errorf("URL rejected after host change: %v", err)
+	}
+
+	if err := signer.Verify(signed, binding, now.Add(2*time.Minute)); err != ErrURLExpired {
+		t.Errorf("expired URL: %v", err)
+	}
+	if err := signer.Verify(u, binding, now); err != ErrURLUnsigned {
+		t.Errorf("unsigned URL: %v", err)
+	}
+	if err := signer.Verify(signed, URLBinding{Method: "DELETE", ClientIP: "203.0.113.7"}, now); err == nil {
+		t.Error("URL accepted for another method")
+	}
+	if err := signer.Verify(signed, URLBinding{Method: "GET", ClientIP: "198.51.100.1"}, now); err == nil {
+		t.Error("URL accepted for another client IP")
+	}
+
+	tampered := map[string]func(url.Values){
+		"query":   func(q url.Values) { q.Set("user", "43") },
+		"expires": func(q url.Values) { q.Set(URLExpiresParam, "1900000000") },
+		"unbind":  func(q url.Values) { q.Del(URLBindParam) },
+		"key id":  func(q url.Values) { q.Set(URLKeyIDParam, "k2") },
+	}
+	for name, tamper := range tampered {
+		forged := *signed
+		query := forged.Query()
+		tamper(query)
+		forged.RawQuery = query.Encode()
+		if err := signer.Verify(&forged, binding, now); err == nil {
+			t.Errorf("%s: tampered URL accepted", name)
+		}
+	}
+
+	path := *signed
+	path.Path = "/download/other.pdf"
+	if err := signer.Verify(&path, binding, now); err == nil {
+		t.Error("URL accepted for another path")
+	}
+}
+
+func TestURLSignerKeyRing(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	old := Key{ID: "old", Method: SigningMethodHMAC{Key: []byte("old"), HashMethod: crypto.SHA256}}
+	current := Key{ID: "new", Method: SigningMethodHMAC{Key: []byte("new"), HashMethod: crypto.SHA256}}
+	ring, err := NewKeyRing([]Key{old, current}, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := URLSigner{Method: ring}
+	u, _ := url.Parse("/download")
+
+	signed, err := signer.Sign(u, now.Add(time.Minute), URLBinding{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed.Query().Get(URLKeyIDParam) != "old" {
+		t.Errorf("key-id %q", signed.Query().Get(URLKeyIDParam))
+	}
+
+	// links signed before a rotation keep working
+	if err := ring.Set([]Key{old, current}, "new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.Verify(signed, URLBinding{}, now); err != nil {
+		t.Errorf("URL signed with the previous key rejected: %v", err)
+	}
+
+	// until the key leaves the ring
+	if err := ring.Set([]Key{current}, "new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.Verify(signed, URLBinding{}, now); err == nil {
+		t.Error("URL signed with a removed key accepted")
+	}
+}
+
+func TestURLSignerCanonicalPath(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	signer := URLSigner{Method: SigningMethodHMAC{Key: []byte("secret"), HashMethod: crypto.SHA256}}
+
+	// withPath returns u with its path replaced by the escaped form p
+	withPath := func(u *url.URL, p string) *url.URL {
+		moved, err := url.Parse("https://files.example" + p + "?" + u.RawQuery)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return moved
+	}
+	sign := func(p string) *url.URL {
+		signed, err := signer.Sign(withPath(&url.URL{}, p), now.Add(time.Minute), URLBinding{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	cases := []struct {
+		signed, verified string
+		ok               bool
+	}{
+		{"/a/b", "/a/./b", true},
+		{"/a/b", "/a//b", true},
+		{"/a/b", "/a%2Fb", true},
+		{"/a/b", "/a/c/../b", true},
+		{"/a/./b", "/a/b", true},
+		{"/a//b", "/a/b", true},
+		{"/files/A", "/files/%41", true},
+		{"/files/%41", "/files/A", true},
+		{"/a/b/", "/a/./b/", true},
+		{"/a/b/", "/a/b", false},
+		{"/a/b", "/a/b/", false},
+		{"/a/b", "/a/../b", false},
+		{"/a%20b", "/a b", true},
+	}
+	for _, c := range cases {
+		signed := sign(c.signed)
+		err := signer.Verify(withPath(signed, c.verified), URLBinding{}, now)
+		if (err == nil) != c.ok {
+			t.Errorf("signed %s, verified %s: %v", c.signed, c.verified, err)
+		}
+	}
+}
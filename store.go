@@ -0,0 +1,149 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+const (
+	parametersCtxKey = "IsylLzqZ.parameters"
+)
+
+// Source tells where a parameter was read from
+type Source string
+
+const (
+	SourceQuery Source = "query"
+	SourceJSON  Source = "json"
+	SourceForm  Source = "form"
+	SourcePath  Source = "path"
+	SourceBody  Source = "body"
+)
+
+// storedParameter is a parameter saved by the RequireParameters* middlewares.
+// Values keeps every raw value of keys that may repeat.
+type storedParameter struct {
+	Value  interface{}
+	Values []string
+	Source Source
+}
+
+type parameterStore map[string]storedParameter
+
+func getParameterStore(r *http.Request) parameterStore {
+	if store, ok := r.Context().Value(parametersCtxKey).(parameterStore); ok {
+		return store
+	}
+	return nil
+}
+
+// withParameters returns a context whose store holds the parameters already
+// in r plus params. Stores are copied so outer handlers never see values
+// added further down the chain.
+func withParameters(r *http.Request, params parameterStore) context.Context {
+	existing := getParameterStore(r)
+	store := make(parameterStore, len(existing)+len(params))
+	for k, v := range existing {
+		store[k] = v
+	}
+	for k, v := range params {
+		store[k] = v
+	}
+	return context.WithValue(r.Context(), parametersCtxKey, store)
+}
+
+// queryParameters collects every query value, applying the array style
+// declared by specs
+func queryParameters(query url.Values, specs []parameterSpec) parameterStore {
+	params := make(parameterStore, len(query))
+	for k, values := range query {
+		params[k] = storedParameter{Value: values[0], Values: values, Source: SourceQuery}
+	}
+
+	for _, spec := range specs {
+		var values []string
+		switch spec.array {
+		case arrayNone:
+			continue
+		case arrayRepeat:
+			values = query[spec.key]
+		case arrayBrackets:
+			values = append(append([]string(nil), query[spec.key+"[]"]...), query[spec.key]...)
+		case arrayComma:
+			for _, value := range query[spec.key] {
+				values = append(values, strings.Split(value, ",")...)
+			}
+		}
+		if len(values) == 0 {
+			delete(params, spec.key)
+			continue
+		}
+		params[spec.key] = storedParameter{Value: values, Values: values, Source: SourceQuery}
+	}
+	return params
+}
+
+// formParameters collects the values of a parsed form like queryParameters.
+// r.Form also holds the query string, so keys missing from the body keep
+// SourceQuery.
+func formParameters(r *http.Request, specs []parameterSpec) parameterStore {
+	params := queryParameters(r.Form, specs)
+	for k, p := range params {
+		if _, ok := r.PostForm[k]; ok {
+			p.Source = SourceForm
+		} else if _, ok := r.PostForm[k+"[]"]; ok {
+			p.Source = SourceForm
+		}
+		params[k] = p
+	}
+	return params
+}
+
+// pathParameters collects the URL params chi matched for the request
+func pathParameters(r *http.Request) parameterStore {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return parameterStore{}
+	}
+	params := make(parameterStore, len(rctx.URLParams.Keys))
+	for i, k := range rctx.URLParams.Keys {
+		value := rctx.URLParams.Values[i]
+		params[k] = storedParameter{Value: value, Values: []string{value}, Source: SourcePath}
+	}
+	return params
+}
+
+// hasValue reports whether p holds a non-empty value
+func (p storedParameter) hasValue() bool {
+	if s, ok := p.Value.(string); ok {
+		return len(s) > 0
+	}
+	for _, value := range p.Values {
+		if len(value) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ParameterValues returns every value sent for a repeated query key, e.g.
+// ["a", "b"] for ?tag=a&tag=b
+func ParameterValues(r *http.Request, k string) []string {
+	if p, ok := getParameterStore(r)[k]; ok {
+		return p.Values
+	}
+	return nil
+}
+
+// ParameterSource returns where the parameter was read from, or "" if it is
+// unknown
+func ParameterSource(r *http.Request, k string) Source {
+	if p, ok := getParameterStore(r)[k]; ok {
+		return p.Source
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestQueryParameters(t *testing.T) {
+	cases := []struct {
+		name  string
+		query string
+		keys  []string
+		key   string
+		value interface{}
+		found bool
+	}{
+		{"single", "name=jane", nil, "name", "jane", true},
+		{"repeated without style keeps the first", "tag=a&tag=b", nil, "tag", "a", true},
+		{"repeat", "tag=a&tag=b", []string{"tag|array=repeat"}, "tag", []string{"a", "b"}, true},
+		{"brackets", "ids[]=1&ids[]=2", []string{"ids|array=brackets"}, "ids", []string{"1", "2"}, true},
+		{"brackets accept the bare key", "ids[]=1&ids=2", []string{"ids|array=brackets"}, "ids", []string{"1", "2"}, true},
+		{"comma", "ids=1,2,3", []string{"ids|array=comma"}, "ids", []string{"1", "2", "3"}, true},
+		{"comma across repeated keys", "ids=1,2&ids=3", []string{"ids|array=comma"}, "ids", []string{"1", "2", "3"}, true},
+		{"comma keeps empty items", "ids=1,,2", []string{"ids|array=comma"}, "ids", []string{"1", "", "2"}, true},
+		{"empty value", "name=", nil, "name", "", true},
+		{"missing array", "other=1", []string{"ids|array=repeat"}, "ids", nil, false},
+		{"mixed styles", "tag=a&tag=b&ids[]=1&ids[]=2&sort=x,y", []string{"tag|array=repeat", "ids|array=brackets", "sort|array=comma"}, "sort", []string{"x", "y"}, true},
+	}
+	for _, c := range cases {
+		query, err := url.ParseQuery(c.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		params := queryParameters(query, mustParseParameterSpecs(c.keys))
+		param, ok := params[c.key]
+		if ok != c.found {
+			t.Errorf("%s: found %v, want %v", c.name, ok, c.found)
+			continue
+		}
+		if ok && !reflect.DeepEqual(param.Value, c.value) {
+			t.Errorf("%s: %#v, want %#v", c.name, param.Value, c.value)
+		}
+		if ok && param.Source != SourceQuery {
+			t.Errorf("%s: source %q", c.name, param.Source)
+		}
+	}
+}
+
+func TestParameterValues(t *testing.T) {
+	var tags, ids, sort, empty []string
+	handler := RequireParametersInQuery("tag", "ids|array=brackets", "sort|array=comma", "empty|optional")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tags = ParameterValues(r, "tag")
+		ids = ParameterValues(r, "ids")
+		sort = ParameterValues(r, "sort")
+		empty = ParameterValues(r, "empty")
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/?tag=a&tag=b&ids[]=1&ids[]=2&sort=name,-age&empty=", nil)
+	if code := serve(handler, r); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	expected := map[string][][]string{
+		"tag":   {tags, {"a", "b"}},
+		"ids":   {ids, {"1", "2"}},
+		"sort":  {sort, {"name", "-age"}},
+		"empty": {empty, {""}},
+	}
+	for k, pair := range expected {
+		if !reflect.DeepEqual(pair[0], pair[1]) {
+			t.Errorf("%s: %q, want %q", k, pair[0], pair[1])
+		}
+	}
+	if ParameterValues(r, "tag") != nil {
+		t.Error("values leaked outside the handler")
+	}
+
+	// empty values do not satisfy a requirement
+	for _, query := range []string{"tag=&ids[]=1&sort=a", "tag=a&ids[]=&sort=a", "tag=a&ids[]=1&sort="} {
+		if code := serve(handler, httptest.NewRequest(http.MethodGet, "/?"+query, nil)); code != http.StatusBadRequest {
+			t.Errorf("?%s: status %d", query, code)
+		}
+	}
+}
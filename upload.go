@@ -0,0 +1,333 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/jeffguorg/middlewares/problem"
+)
+
+const (
+	uploadsCtxKey = "IsylLzqZ.uploads"
+
+	defaultMaxFileSize  = 10 << 20
+	defaultMaxTotalSize = 32 << 20
+	defaultMaxFiles     = 16
+	defaultMaxValues    = 1000
+	maxFormValueSize    = 1 << 20
+	sniffLen            = 512
+)
+
+var (
+	ErrTooManyFiles      = errors.New("too many files")
+	ErrTooManyValues     = errors.New("too many form values")
+	ErrValueTooLarge     = errors.New("form value is too large")
+	ErrFileTooLarge      = errors.New("file is too large")
+	ErrUploadTooLarge    = errors.New("upload is too large")
+	ErrFileTypeForbidden = errors.New("file type is not allowed")
+	ErrFileStreamed      = errors.New("file was streamed by Upload, read it as an UploadedFile")
+)
+
+// UploadedFile describes a file spooled to disk by Upload
+type UploadedFile struct {
+	Field       string
+	Filename    string
+	Size        int64
+	ContentType string // sniffed from the content, not taken from the client
+	SHA256      string // hex encoded
+	Path        string // removed once the request is handled
+}
+
+// Open opens the spooled file for reading
+func (f UploadedFile) Open() (*os.File, error) {
+	return os.Open(f.Path)
+}
+
+type uploadConfig struct {
+	maxFileSize  int64
+	maxTotalSize int64
+	maxFiles     int
+	maxValues    int
+	allowedTypes []string
+	tempDir      string
+}
+
+// UploadOption configures Upload
+type UploadOption func(*uploadConfig)
+
+// MaxFileSize limits the size of every single file
+func MaxFileSize(size int64) UploadOption {
+	return func(c *uploadConfig) {
+		c.maxFileSize = size
+	}
+}
+
+// MaxTotalSize limits the size of all files and form values of a request
+// together
+func MaxTotalSize(size int64) UploadOption {
+	return func(c *uploadConfig) {
+		c.maxTotalSize = size
+	}
+}
+
+// MaxFiles limits how many files a request may carry
+func MaxFiles(count int) UploadOption {
+	return func(c *uploadConfig) {
+		c.maxFiles = count
+	}
+}
+
+// MaxValues limits how many plain form values a request may carry
+func MaxValues(count int) UploadOption {
+	return func(c *uploadConfig) {
+		c.maxValues = count
+	}
+}
+
+// AllowTypes restricts uploads to the given sniffed MIME types. A type may
+// end with "/*" to allow a whole family such as "image/*".
+func AllowTypes(types ...string) UploadOption {
+	return func(c *uploadConfig) {
+		c.allowedTypes = append(c.allowedTypes, types...)
+	}
+}
+
+// UploadDir sets the directory files are spooled to, os.TempDir by default
+func UploadDir(dir string) UploadOption {
+	return func(c *uploadConfig) {
+		c.tempDir = dir
+	}
+}
+
+// Upload streams a multipart/form-data body, spooling every file to a
+// temporary file that is removed after the request. fields must each carry
+// at least one file. Plain form values stay available through r.FormValue,
+// and files through UploadedFiles.
+//
+// The files never reach r.MultipartForm, so r.FormFile finds none.
+// RequireFilesInForm checks the uploaded files instead, and Bind fills
+// UploadedFile fields but rejects *multipart.FileHeader ones with
+// ErrFileStreamed.
+//
+// Files over the size caps, form values over 1 MiB and requests over the
+// value count are rejected with 413, files whose sniffed type is not allowed
+// with 415.
+func Upload(fields []string, options ...UploadOption) func(http.Handler) http.Handler {
+	config := uploadConfig{
+		maxFileSize:  defaultMaxFileSize,
+		maxTotalSize: defaultMaxTotalSize,
+		maxFiles:     defaultMaxFiles,
+		maxValues:    defaultMaxValues,
+	}
+	for _, opt := range options {
+		opt(&config)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reader, err := r.MultipartReader()
+			if err != nil {
+				problem.Respond(w, r, http.StatusBadRequest, err)
+				return
+			}
+
+			uploads := make(map[string][]UploadedFile)
+			defer func() {
+				for _, files := range uploads {
+					for _, file := range files {
+						_ = os.Remove(file.Path)
+					}
+				}
+			}()
+
+			values := make(url.Values)
+			var count, valueCount int
+			var total int64
+			for {
+				part, err := reader.NextPart()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					problem.Respond(w, r, http.StatusBadRequest, err)
+					return
+				}
+
+				name := part.FormName()
+				if part.FileName() == "" {
+					valueCount++
+					if valueCount > config.maxValues {
+						problem.Respond(w, r, http.StatusRequestEntityTooLarge, ErrTooManyValues)
+						return
+					}
+					value, status, err := readFormValue(part, config.maxTotalSize-total)
+					if err != nil {
+						problem.Respond(w, r, status, ValidationError{{Field: name, Reason: err.Error()}})
+						return
+					}
+					total += int64(len(value))
+					values.Add(name, value)
+					continue
+				}
+
+				count++
+				if count > config.maxFiles {
+					problem.Respond(w, r, http.StatusRequestEntityTooLarge, ErrTooManyFiles)
+					return
+				}
+
+				file, status, err := config.spool(part, config.maxTotalSize-total)
+				if file.Path != "" {
+					uploads[name] = append(uploads[name], file)
+				}
+				if err != nil {
+					problem.Respond(w, r, status, ValidationError{{Field: name, Reason: err.Error()}})
+					return
+				}
+				total += file.Size
+			}
+
+			var errs ValidationError
+			for _, field := range fields {
+				if len(uploads[field]) == 0 {
+					errs = append(errs, FieldError{Field: field, Reason: "file is required"})
+				}
+			}
+			if len(errs) > 0 {
+				problem.Respond(w, r, http.StatusBadRequest, errs)
+				return
+			}
+
+			r.MultipartForm = &multipart.Form{Value: values, File: map[string][]*multipart.FileHeader{}}
+			r.PostForm = values
+			r.Form = make(url.Values)
+			for k, v := range r.URL.Query() {
+				r.Form[k] = append(r.Form[k], v...)
+			}
+			for k, v := range values {
+				r.Form[k] = append(r.Form[k], v...)
+			}
+
+			ctx := context.WithValue(r.Context(), uploadsCtxKey, uploads)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// readFormValue reads a plain form value of at most 1 MiB and remaining
+// bytes
+func readFormValue(part *multipart.Part, remaining int64) (string, int, error) {
+	limit := int64(maxFormValueSize)
+	if remaining < limit {
+		limit = remaining
+	}
+	value, err := ioutil.ReadAll(io.LimitReader(part, limit+1))
+	if err != nil {
+		return "", http.StatusBadRequest, err
+	}
+	if int64(len(value)) > limit {
+		if limit < maxFormValueSize {
+			return "", http.StatusRequestEntityTooLarge, ErrUploadTooLarge
+		}
+		return "", http.StatusRequestEntityTooLarge, ErrValueTooLarge
+	}
+	return string(value), 0, nil
+}
+
+// spool writes part to a temporary file. The returned file has a Path as
+// soon as the temporary file exists, so callers can clean it up on error.
+func (config uploadConfig) spool(part *multipart.Part, remaining int64) (UploadedFile, int, error) {
+	upload := UploadedFile{
+		Field:    part.FormName(),
+		Filename: part.FileName(),
+	}
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(part, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return upload, http.StatusBadRequest, err
+	}
+	head = head[:n]
+
+	upload.ContentType, _, _ = mime.ParseMediaType(http.DetectContentType(head))
+	if !config.allowed(upload.ContentType) {
+		return upload, http.StatusUnsupportedMediaType, fmt.Errorf("%w: %s", ErrFileTypeForbidden, upload.ContentType)
+	}
+
+	tmp, err := ioutil.TempFile(config.tempDir, "upload-")
+	if err != nil {
+		return upload, http.StatusInternalServerError, err
+	}
+	defer tmp.Close()
+	upload.Path = tmp.Name()
+
+	limit := config.maxFileSize
+	if remaining < limit {
+		limit = remaining
+	}
+
+	hasher := sha256.New()
+	content := io.MultiReader(bytes.NewReader(head), part)
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), io.LimitReader(content, limit+1))
+	if err != nil {
+		return upload, http.StatusBadRequest, err
+	}
+	if size > limit {
+		if limit < config.maxFileSize {
+			return upload, http.StatusRequestEntityTooLarge, ErrUploadTooLarge
+		}
+		return upload, http.StatusRequestEntityTooLarge, ErrFileTooLarge
+	}
+
+	upload.Size = size
+	upload.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+	return upload, 0, nil
+}
+
+func (config uploadConfig) allowed(contentType string) bool {
+	if len(config.allowedTypes) == 0 {
+		return true
+	}
+	for _, allowed := range config.allowedTypes {
+		if allowed == contentType {
+			return true
+		}
+		if strings.HasSuffix(allowed, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// UploadedFiles returns the files Upload received for field
+func UploadedFiles(r *http.Request, field string) []UploadedFile {
+	uploads, _ := getUploads(r)
+	return uploads[field]
+}
+
+// getUploads returns the files received by Upload and whether it handled r
+func getUploads(r *http.Request) (map[string][]UploadedFile, bool) {
+	uploads, ok := r.Context().Value(uploadsCtxKey).(map[string][]UploadedFile)
+	return uploads, ok
+}
+
+// GetUploadedFile returns the first file Upload received for field
+func GetUploadedFile(r *http.Request, field string) (UploadedFile, bool) {
+	files := UploadedFiles(r, field)
+	if len(files) == 0 {
+		return UploadedFile{}, false
+	}
+	return files[0], true
+}
@@ -0,0 +1,139 @@
+package middlewares
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type uploadPart struct {
+	name, filename, content string
+}
+
+func uploadRequest(t *testing.T, handler http.Handler, parts ...uploadPart) int {
+	return serve(handler, multipartRequest(t, parts...))
+}
+
+func multipartRequest(t *testing.T, parts ...uploadPart) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range parts {
+		var err error
+		if part.filename != "" {
+			w, e := writer.CreateFormFile(part.name, part.filename)
+			err = e
+			if err == nil {
+				_, err = w.Write([]byte(part.content))
+			}
+		} else {
+			err = writer.WriteField(part.name, part.content)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	return r
+}
+
+func TestUpload(t *testing.T) {
+	var title string
+	var file UploadedFile
+	handler := Upload([]string{"doc"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		title = r.FormValue("title")
+		file, _ = GetUploadedFile(r, "doc")
+		content, err := ioutil.ReadFile(file.Path)
+		if err != nil || string(content) != "hello" {
+			t.Errorf("spooled content %q: %v", content, err)
+		}
+	}))
+	if code := uploadRequest(t, handler, uploadPart{"title", "", "report"}, uploadPart{"doc", "a.txt", "hello"}); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if title != "report" || file.Size != 5 || !strings.HasPrefix(file.ContentType, "text/plain") {
+		t.Errorf("title %q, file %+v", title, file)
+	}
+	if code := uploadRequest(t, handler, uploadPart{"title", "", "report"}); code != http.StatusBadRequest {
+		t.Errorf("missing file: status %d", code)
+	}
+}
+
+func TestUploadLimits(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	big := strings.Repeat("x", maxFormValueSize+1)
+
+	if code := uploadRequest(t, Upload(nil)(ok), uploadPart{"title", "", big}); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized value: status %d", code)
+	}
+	if code := uploadRequest(t, Upload(nil, MaxTotalSize(10))(ok), uploadPart{"a", "", "123456"}, uploadPart{"b", "", "123456"}); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("values over total size: status %d", code)
+	}
+	if code := uploadRequest(t, Upload(nil, MaxValues(2))(ok), uploadPart{"a", "", "1"}, uploadPart{"b", "", "2"}, uploadPart{"c", "", "3"}); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("too many values: status %d", code)
+	}
+	if code := uploadRequest(t, Upload(nil, MaxFileSize(4))(ok), uploadPart{"doc", "a.txt", "hello"}); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized file: status %d", code)
+	}
+	if code := uploadRequest(t, Upload(nil, AllowTypes("image/*"))(ok), uploadPart{"doc", "a.png", "hello"}); code != http.StatusUnsupportedMediaType {
+		t.Errorf("forbidden type: status %d", code)
+	}
+}
+
+func TestFileTypeForbiddenUnwraps(t *testing.T) {
+	config := uploadConfig{maxFileSize: 10, allowedTypes: []string{"image/png"}}
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	w, _ := writer.CreateFormFile("doc", "a.txt")
+	_, _ = w.Write([]byte("hello"))
+	_ = writer.Close()
+	part, err := multipart.NewReader(&body, writer.Boundary()).NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := config.spool(part, 10); !errors.Is(err, ErrFileTypeForbidden) {
+		t.Errorf("error %v does not wrap ErrFileTypeForbidden", err)
+	}
+}
+
+type uploadedForm struct {
+	Title string       `form:"title"`
+	Doc   UploadedFile `form:"doc"`
+}
+
+type fileHeaderForm struct {
+	Doc *multipart.FileHeader `form:"doc"`
+}
+
+func TestUploadThenBind(t *testing.T) {
+	var form *uploadedForm
+	handler := Upload(nil)(RequireFilesInForm("doc")(Bind(&uploadedForm{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		form = Bound(r).(*uploadedForm)
+	}))))
+	if code := uploadRequest(t, handler, uploadPart{"title", "", "report"}, uploadPart{"doc", "a.txt", "hello"}); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if form.Title != "report" || form.Doc.Filename != "a.txt" || form.Doc.Size != 5 {
+		t.Errorf("bound %+v", form)
+	}
+	if code := uploadRequest(t, handler, uploadPart{"title", "", "report"}); code != http.StatusBadRequest {
+		t.Errorf("missing file: status %d", code)
+	}
+
+	// the spooled files have no multipart.FileHeader to bind to
+	w := httptest.NewRecorder()
+	Upload(nil)(Bind(&fileHeaderForm{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("file header bound after Upload")
+	}))).ServeHTTP(w, multipartRequest(t, uploadPart{"doc", "a.txt", "hello"}))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrFileStreamed.Error()) {
+		t.Errorf("status %d, body %s", w.Code, w.Body.String())
+	}
+}
@@ -5,112 +5,134 @@ Package middlewares contains a set of middlewares to parse and validate headers
 package middlewares
 
 import (
-	"context"
+	"github.com/jeffguorg/middlewares/problem"
 	"github.com/json-iterator/go"
-	"io/ioutil"
 	"net/http"
-	"strconv"
 )
 
 var (
-	ctxPrefix = "IsylLzqZ"
-	json      = jsoniter.ConfigCompatibleWithStandardLibrary
+	json = jsoniter.ConfigCompatibleWithStandardLibrary
 )
 
 // RequireParametersInQuery checks for parameters existence in query string
-// and store all pairs in context
+// and store all pairs in context. Repeated keys keep every value, available
+// through ParameterValues. Keys may carry validation rules and an array
+// style, see parameterSpec for the syntax.
 func RequireParametersInQuery(keys ...string) func(http.Handler) http.Handler {
+	specs := mustParseParameterSpecs(keys)
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ctx := r.Context()
-			for _, key := range keys {
-				if len(r.URL.Query().Get(key)) == 0 {
-					w.WriteHeader(http.StatusBadRequest)
-					return
-				}
+			params := queryParameters(r.URL.Query(), specs)
+			var errs ValidationError
+			for _, spec := range specs {
+				param, ok := params[spec.key]
+				errs = spec.check(param.Value, ok && param.hasValue(), errs)
 			}
-			for k := range r.URL.Query() {
-				ctx = context.WithValue(ctx, ctxPrefix+k, r.URL.Query().Get(k))
+			if len(errs) > 0 {
+				problem.Respond(w, r, http.StatusBadRequest, errs)
+				return
 			}
-			next.ServeHTTP(w, r.WithContext(ctx))
+			next.ServeHTTP(w, r.WithContext(withParameters(r, params)))
 		})
 	}
 }
 
-// RequireParametersInQuery checks for parameters existence in query string
-// and store all pairs in context
-func RequireParametersInJSON(keys ...string) func(http.Handler) http.Handler {
+// RequireParametersInPath checks the URL params matched by chi and store
+// them in context next to query and JSON parameters. Keys may carry
+// validation rules such as "id|int" or "slug|pattern=^[a-z-]+$", see
+// parameterSpec for the syntax.
+//
+// chi fills URL params while routing, so this has to be mounted with
+// router.With or inside a route rather than router.Use.
+func RequireParametersInPath(keys ...string) func(http.Handler) http.Handler {
+	specs := mustParseParameterSpecs(keys)
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			var values map[string]interface{}
-			var ctx = r.Context()
-
-			buf := GetBodyContent(r)
-
-			if buf == nil {
-				bodyBuf, err := ioutil.ReadAll(r.Body)
-				if err != nil {
-					w.WriteHeader(http.StatusInternalServerError)
-					return
-				}
-				buf = bodyBuf
+			params := pathParameters(r)
+			var errs ValidationError
+			for _, spec := range specs {
+				param, ok := params[spec.key]
+				errs = spec.check(param.Value, ok && param.hasValue(), errs)
 			}
-
-			if err := json.Unmarshal(buf, &values); err != nil {
-				w.WriteHeader(http.StatusBadRequest)
+			if len(errs) > 0 {
+				problem.Respond(w, r, http.StatusBadRequest, errs)
 				return
 			}
-
-			for _, key := range keys {
-				if _, ok := values[key]; !ok {
-					w.WriteHeader(http.StatusBadRequest)
-					return
-				}
-			}
-			for k, v := range values {
-				ctx = context.WithValue(ctx, ctxPrefix+k, v)
-			}
-			next.ServeHTTP(w, r.WithContext(ctx))
+			next.ServeHTTP(w, r.WithContext(withParameters(r, params)))
 		})
 	}
 }
 
-// RequireParametersInForm checks if key exists in form
+// RequireParametersInJSON checks for parameters existence in the body and
+// store all pairs in context. Despite its name the body is decoded with the
+// codec registered for its Content-Type, JSON when there is none.
+//
+// Deprecated: use RequireParametersInBody, which it calls.
+func RequireParametersInJSON(keys ...string) func(http.Handler) http.Handler {
+	return RequireParametersInBody(keys...)
+}
+
+// RequireParametersInForm checks for parameters existence in the form and
+// store all pairs in context. Like r.FormValue, keys may also come from the
+// query string; ParameterSource tells them apart. Keys may carry validation
+// rules and an array style, see parameterSpec for the syntax.
 func RequireParametersInForm(keys ...string) func(http.Handler) http.Handler {
+	specs := mustParseParameterSpecs(keys)
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			for _, key := range keys {
-				if v := r.FormValue(key); len(v) == 0 {
-					w.WriteHeader(http.StatusBadRequest)
-					return
-				}
+			if err := r.ParseMultipartForm(defaultMaxMemory); err != nil && err != http.ErrNotMultipart {
+				problem.Respond(w, r, http.StatusBadRequest, err)
+				return
 			}
-			next.ServeHTTP(w, r)
+			params := formParameters(r, specs)
+			var errs ValidationError
+			for _, spec := range specs {
+				param, ok := params[spec.key]
+				errs = spec.check(param.Value, ok && param.hasValue(), errs)
+			}
+			if len(errs) > 0 {
+				problem.Respond(w, r, http.StatusBadRequest, errs)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(withParameters(r, params)))
 		})
 	}
 }
 
-// RequireFilesInForm checks if key exists in form
+// RequireFilesInForm checks if key exists in form, or among the files
+// received by Upload
 func RequireFilesInForm(keys ...string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uploads, uploaded := getUploads(r)
+			var errs ValidationError
 			for _, key := range keys {
-				if _, _, err := r.FormFile(key); err != nil {
-					w.WriteHeader(http.StatusBadRequest)
-					return
+				if uploaded {
+					if len(uploads[key]) == 0 {
+						errs = append(errs, FieldError{Field: key, Reason: "file is required"})
+					}
+				} else if _, _, err := r.FormFile(key); err != nil {
+					errs = append(errs, FieldError{Field: key, Reason: "file is required"})
 				}
 			}
+			if len(errs) > 0 {
+				problem.Respond(w, r, http.StatusBadRequest, errs)
+				return
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
 func Parameter(r *http.Request, k string) interface{} {
-	return r.Context().Value(ctxPrefix + k)
+	if p, ok := getParameterStore(r)[k]; ok {
+		return p.Value
+	}
+	return nil
 }
 
 func ParameterStringWithDefault(r *http.Request, k string, d string) string {
-	if param := r.Context().Value(ctxPrefix + k); param != nil {
+	if param := Parameter(r, k); param != nil {
 		if v, ok := param.(string); ok {
 			return v
 		}
@@ -120,33 +142,8 @@ func ParameterStringWithDefault(r *http.Request, k string, d string) string {
 }
 
 func ParameterIntWithDefault(r *http.Request, k string, d int) int {
-	if param := r.Context().Value(ctxPrefix + k); param != nil {
-		switch v := param.(type) {
-		case int:
-			return int(v)
-		case int8:
-			return int(v)
-		case int16:
-			return int(v)
-		case int32:
-			return int(v)
-		case int64:
-			return int(v)
-		case uint:
-			return int(v)
-		case uint8:
-			return int(v)
-		case uint16:
-			return int(v)
-		case uint32:
-			return int(v)
-		case uint64:
-			return int(v)
-		case string:
-			if n, err := strconv.Atoi(v); err == nil {
-				return n
-			}
-		}
+	if n, err := ParameterInt(r, k); err == nil {
+		return n
 	}
 	return d
 }
@@ -0,0 +1,80 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestRequireParametersInForm(t *testing.T) {
+	var (
+		name   interface{}
+		tags   []string
+		source Source
+		query  Source
+	)
+	handler := RequireParametersInForm("name|min=2", "tags|optional,array=brackets", "page|optional")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name = Parameter(r, "name")
+		tags, _ = ParameterStringSlice(r, "tags")
+		source = ParameterSource(r, "name")
+		query = ParameterSource(r, "page")
+	}))
+
+	form := url.Values{"name": {"jane"}, "tags[]": {"a", "b"}}
+	r := httptest.NewRequest(http.MethodPost, "/?page=2", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if code := serve(handler, r); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if name != "jane" || source != SourceForm {
+		t.Errorf("name = %v from %q", name, source)
+	}
+	if !reflect.DeepEqual(tags, []string{"a", "b"}) {
+		t.Errorf("tags = %v", tags)
+	}
+	if query != SourceQuery {
+		t.Errorf("page source = %q, want %q", query, SourceQuery)
+	}
+
+	cases := map[string]int{
+		"name=jane": http.StatusOK,
+		"name=j":    http.StatusBadRequest,
+		"":          http.StatusBadRequest,
+	}
+	for body, status := range cases {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if code := serve(handler, r); code != status {
+			t.Errorf("%q: status %d, want %d", body, code, status)
+		}
+	}
+}
+
+func TestRequireParametersInPath(t *testing.T) {
+	var (
+		id     int
+		source Source
+	)
+	router := chi.NewRouter()
+	router.With(RequireParametersInPath("id|int,gte=1")).Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, _ = ParameterInt(r, "id")
+		source = ParameterSource(r, "id")
+	})
+
+	if code := serve(router, httptest.NewRequest(http.MethodGet, "/users/42", nil)); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if id != 42 || source != SourcePath {
+		t.Errorf("id = %d from %q", id, source)
+	}
+	for _, path := range []string{"/users/0", "/users/abc"} {
+		if code := serve(router, httptest.NewRequest(http.MethodGet, path, nil)); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d", path, code)
+		}
+	}
+}
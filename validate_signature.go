@@ -2,27 +2,88 @@ package middlewares
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"github.com/jeffguorg/middlewares/problem"
+	"github.com/jeffguorg/middlewares/replay"
 	"github.com/jeffguorg/middlewares/signature"
+	"github.com/jeffguorg/middlewares/signature/httpsig"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"os"
+	"time"
 )
 
 const (
-	HttpBodyKey = "httpBodyStore"
+	HttpBodyKey       = "httpBodyStore"
+	SignatureKeyIDKey = "httpSignatureKeyID"
 )
 
-func StoreBodyInContext() func(handler http.Handler) http.Handler {
+// StoreBodyInContext reads the whole body, saves it in context for
+// GetBodyContent and puts back a re-readable r.Body. See BodyOption for size
+// limits, spilling to disk and decompression.
+func StoreBodyInContext(options ...BodyOption) func(handler http.Handler) http.Handler {
+	var config bodyConfig
+	for _, opt := range options {
+		opt(&config)
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			body, err := ioutil.ReadAll(r.Body)
+			var wire *wireHashes
+			if encoding := contentEncoding(r); config.decompress && encoding != "" && encoding != "identity" {
+				wire = hashWire(r)
+			}
+			body, spilled, status, err := config.read(r)
 			if err != nil {
+				problem.Respond(w, r, status, err)
+				return
+			}
+			ctx := r.Context()
+			if wire != nil {
+				sums, err := wire.sums(config.maxSize)
+				if err == ErrBodyTooLarge {
+					problem.Respond(w, r, http.StatusRequestEntityTooLarge, err)
+					return
+				}
+				if err != nil {
+					problem.Respond(w, r, http.StatusBadRequest, err)
+					return
+				}
+				ctx = context.WithValue(ctx, wireDigestsCtxKey, sums)
+			}
+			if config.decompress {
+				r.Header.Del("Content-Encoding")
+			}
+
+			if spilled == nil {
+				restoreBody(r, body)
+				next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, HttpBodyKey, body)))
 				return
 			}
-			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), HttpBodyKey, body)))
+
+			defer os.Remove(spilled.path)
+			file, err := os.Open(spilled.path)
+			if err != nil {
+				problem.Respond(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			defer file.Close()
+			r.Body = file
+			r.GetBody = func() (io.ReadCloser, error) {
+				return os.Open(spilled.path)
+			}
+			r.ContentLength = spilled.size
+			next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, HttpBodyKey, spilled)))
 		})
 	}
 }
 
+// GetBodyContent returns the body saved by StoreBodyInContext. A body spilled
+// to disk is read back into memory on every call, use OpenBody to stream it
+// instead.
 func GetBodyContent(request *http.Request) []byte {
 	result := request.Context().Value(HttpBodyKey)
 	switch resultType := result.(type) {
@@ -32,28 +93,77 @@ func GetBodyContent(request *http.Request) []byte {
 		return []byte(resultType)
 	case []byte:
 		return resultType
+	case *spilledBody:
+		content, err := ioutil.ReadFile(resultType.path)
+		if err != nil {
+			return nil
+		}
+		return content
 	default:
 		return nil
 	}
 }
 
-func CheckSignature(signingMethod signature.SigningMethod, makeSigningString func(r *http.Request) (string, error), getSignature func(r *http.Request) (string, error)) func(handler http.Handler) http.Handler {
+type signatureConfig struct {
+	guard *replay.Guard
+}
+
+// SignatureOption configures CheckSignature and CheckMessageSignature
+type SignatureOption func(*signatureConfig)
+
+// WithReplayGuard checks requests with guard once their signature is
+// verified, like CheckReplay chained after the signature middleware. The
+// timestamp and nonce must be covered by the signature.
+func WithReplayGuard(guard replay.Guard) SignatureOption {
+	return func(c *signatureConfig) {
+		c.guard = &guard
+	}
+}
+
+func newSignatureConfig(options []SignatureOption) signatureConfig {
+	var config signatureConfig
+	for _, option := range options {
+		option(&config)
+	}
+	return config
+}
+
+// checkReplay responds and returns false when the replay guard rejects r
+func (config signatureConfig) checkReplay(w http.ResponseWriter, r *http.Request) bool {
+	if config.guard == nil {
+		return true
+	}
+	if err := config.guard.Check(r); err != nil {
+		problem.Respond(w, r, replayStatus(err), err)
+		return false
+	}
+	return true
+}
+
+// CheckSignature verifies the signature returned by getSignature over the
+// string built by makeSigningString. See WithReplayGuard to also reject
+// replayed requests.
+func CheckSignature(signingMethod signature.SigningMethod, makeSigningString func(r *http.Request) (string, error), getSignature func(r *http.Request) (string, error), options ...SignatureOption) func(handler http.Handler) http.Handler {
+	config := newSignatureConfig(options)
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			sign, err := getSignature(r)
 			if err != nil {
-				w.WriteHeader(http.StatusUnauthorized)
+				problem.Respond(w, r, http.StatusUnauthorized, fmt.Errorf("cannot read signature: %v", err))
 				return
 			}
 
 			signingString, err := makeSigningString(r)
 			if err != nil {
-				w.WriteHeader(http.StatusUnauthorized)
+				problem.Respond(w, r, http.StatusUnauthorized, fmt.Errorf("cannot build signing string: %v", err))
 				return
 			}
 
 			if err := signingMethod.Verify(signingString, sign); err != nil {
-				w.WriteHeader(http.StatusUnauthorized)
+				problem.Respond(w, r, http.StatusUnauthorized, err)
+				return
+			}
+			if !config.checkReplay(w, r) {
 				return
 			}
 
@@ -61,3 +171,104 @@ func CheckSignature(signingMethod signature.SigningMethod, makeSigningString fun
 		})
 	}
 }
+
+// CheckMessageSignature verifies RFC 9421 HTTP Message Signatures with
+// verifier and stores the key id of the signature in context. See
+// WithReplayGuard to also reject replayed requests.
+func CheckMessageSignature(verifier httpsig.Verifier, options ...SignatureOption) func(handler http.Handler) http.Handler {
+	config := newSignatureConfig(options)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			keyID, err := verifier.Verify(r)
+			if err != nil {
+				problem.Respond(w, r, http.StatusUnauthorized, err)
+				return
+			}
+			if !config.checkReplay(w, r) {
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), SignatureKeyIDKey, keyID)))
+		})
+	}
+}
+
+// GetSignatureKeyID returns the key id stored by CheckMessageSignature
+func GetSignatureKeyID(r *http.Request) string {
+	keyID, _ := r.Context().Value(SignatureKeyIDKey).(string)
+	return keyID
+}
+
+// CheckReplay rejects requests that are stale or reuse a nonce according to
+// guard. It must be chained after the signature middleware, so that only
+// authentic requests record nonces; WithReplayGuard does both in one step.
+//
+// Stale and replayed requests get 401, requests whose timestamp or nonce is
+// missing or malformed 400, and nonce store failures 500.
+func CheckReplay(guard replay.Guard) func(handler http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := guard.Check(r); err != nil {
+				problem.Respond(w, r, replayStatus(err), err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func replayStatus(err error) int {
+	var storeErr *replay.StoreError
+	switch {
+	case errors.As(err, &storeErr):
+		return http.StatusInternalServerError
+	case err == replay.ErrTimestampWindow, err == replay.ErrNonceReused:
+		return http.StatusUnauthorized
+	}
+	// anything else comes from reading the timestamp and nonce, including
+	// the errors of a custom Guard.Extract
+	return http.StatusBadRequest
+}
+
+type presignedURLConfig struct {
+	clientIP func(r *http.Request) string
+}
+
+// PresignedURLOption configures CheckPresignedURL
+type PresignedURLOption func(*presignedURLConfig)
+
+// PresignedClientIP sets how the client IP compared with IP bound URLs is
+// found, e.g. TrustedProxyClientIP behind a reverse proxy
+func PresignedClientIP(clientIP func(r *http.Request) string) PresignedURLOption {
+	return func(c *presignedURLConfig) {
+		c.clientIP = clientIP
+	}
+}
+
+// CheckPresignedURL rejects requests whose URL is not signed by signer,
+// has expired or does not match the method or client IP it was bound to.
+// The client IP is the host of RemoteAddr unless PresignedClientIP is given,
+// so behind a proxy every request has the proxy address.
+func CheckPresignedURL(signer signature.URLSigner, options ...PresignedURLOption) func(handler http.Handler) http.Handler {
+	config := presignedURLConfig{clientIP: clientIP}
+	for _, option := range options {
+		option(&config)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			binding := signature.URLBinding{Method: r.Method, ClientIP: config.clientIP(r)}
+			if err := signer.Verify(r.URL, binding, time.Now()); err != nil {
+				problem.Respond(w, r, http.StatusForbidden, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,145 @@
+package middlewares
+
+import (
+	"crypto"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jeffguorg/middlewares/replay"
+	"github.com/jeffguorg/middlewares/signature"
+)
+
+func signedRequest(t *testing.T, method signature.SigningMethod, timestamp, nonce string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/orders", nil)
+	r.Header.Set(replay.DefaultTimestampHeader, timestamp)
+	r.Header.Set(replay.DefaultNonceHeader, nonce)
+	sign, err := method.Sign(timestamp + "\n" + nonce + "\n" + r.URL.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("X-Signature", sign)
+	return r
+}
+
+func headerSigningString(r *http.Request) (string, error) {
+	return r.Header.Get(replay.DefaultTimestampHeader) + "\n" + r.Header.Get(replay.DefaultNonceHeader) + "\n" + r.URL.Path, nil
+}
+
+func serve(handler http.Handler, r *http.Request) int {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestCheckSignatureWithReplayGuard(t *testing.T) {
+	method := signature.SigningMethodHMAC{Key: []byte("secret"), HashMethod: crypto.SHA256}
+	guard := replay.Guard{Store: replay.NewMemoryStore(0)}
+	handler := CheckSignature(method, headerSigningString, headerSignature("X-Signature"), WithReplayGuard(guard))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	if code := serve(handler, signedRequest(t, method, now, "n1")); code != http.StatusOK {
+		t.Fatalf("signed request: status %d", code)
+	}
+	if code := serve(handler, signedRequest(t, method, now, "n1")); code != http.StatusUnauthorized {
+		t.Errorf("replayed request: status %d", code)
+	}
+
+	// a forged request must not burn the nonce of the genuine one
+	forged := signedRequest(t, method, now, "n2")
+	forged.Header.Set("X-Signature", "forged")
+	if code := serve(handler, forged); code != http.StatusUnauthorized {
+		t.Errorf("forged request: status %d", code)
+	}
+	if code := serve(handler, signedRequest(t, method, now, "n2")); code != http.StatusOK {
+		t.Errorf("request after forgery: status %d", code)
+	}
+
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	if code := serve(handler, signedRequest(t, method, stale, "n3")); code != http.StatusUnauthorized {
+		t.Errorf("stale request: status %d", code)
+	}
+}
+
+type failingNonceStore struct{}
+
+func (failingNonceStore) Seen(string, time.Duration) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestCheckReplayStatus(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	request := func(timestamp, nonce string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(replay.DefaultTimestampHeader, timestamp)
+		r.Header.Set(replay.DefaultNonceHeader, nonce)
+		return r
+	}
+
+	memory := CheckReplay(replay.Guard{Store: replay.NewMemoryStore(0)})(ok)
+	if code := serve(memory, request("soon", "a")); code != http.StatusBadRequest {
+		t.Errorf("malformed timestamp: status %d", code)
+	}
+	if code := serve(memory, request(now, "")); code != http.StatusBadRequest {
+		t.Errorf("missing nonce: status %d", code)
+	}
+
+	extract := CheckReplay(replay.Guard{
+		Store: replay.NewMemoryStore(0),
+		Extract: func(r *http.Request) (time.Time, string, error) {
+			return time.Time{}, "", errors.New("nonce parameter is malformed")
+		},
+	})(ok)
+	if code := serve(extract, request(now, "a")); code != http.StatusBadRequest {
+		t.Errorf("Extract error: status %d", code)
+	}
+
+	failing := CheckReplay(replay.Guard{Store: failingNonceStore{}})(ok)
+	if code := serve(failing, request(now, "a")); code != http.StatusInternalServerError {
+		t.Errorf("store failure: status %d", code)
+	}
+}
+
+func TestCheckPresignedURLBehindProxy(t *testing.T) {
+	signer := signature.URLSigner{Method: signature.SigningMethodHMAC{Key: []byte("secret"), HashMethod: crypto.SHA256}}
+	u, _ := url.Parse("/download")
+	signed, err := signer.Sign(u, time.Now().Add(time.Minute), signature.URLBinding{ClientIP: "203.0.113.7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	request := func(remoteAddr, forwardedFor string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, signed.String(), nil)
+		r.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		return r
+	}
+
+	direct := CheckPresignedURL(signer)(ok)
+	if code := serve(direct, request("203.0.113.7:41000", "")); code != http.StatusOK {
+		t.Errorf("direct client: status %d", code)
+	}
+	if code := serve(direct, request("10.0.0.2:41000", "203.0.113.7")); code != http.StatusForbidden {
+		t.Errorf("forwarded address trusted without proxy option: status %d", code)
+	}
+
+	proxied := CheckPresignedURL(signer, PresignedClientIP(TrustedProxyClientIP("10.0.0.0/8")))(ok)
+	if code := serve(proxied, request("10.0.0.2:41000", "203.0.113.7")); code != http.StatusOK {
+		t.Errorf("client behind trusted proxy: status %d", code)
+	}
+	// the client may prepend anything to X-Forwarded-For, only the entry
+	// added by the proxy counts
+	if code := serve(proxied, request("10.0.0.2:41000", "203.0.113.7, 198.51.100.1")); code != http.StatusForbidden {
+		t.Errorf("spoofed X-Forwarded-For: status %d", code)
+	}
+	if code := serve(proxied, request("198.51.100.1:41000", "203.0.113.7")); code != http.StatusForbidden {
+		t.Errorf("X-Forwarded-For from untrusted peer: status %d", code)
+	}
+}
@@ -0,0 +1,199 @@
+package middlewares
+
+import (
+	"crypto"
+	_ "crypto/sha1" // Twilio signatures
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/jeffguorg/middlewares/signature"
+)
+
+// defaultWebhookTolerance is the age accepted for timestamped webhooks when
+// no tolerance is given, matching the providers' own libraries
+const defaultWebhookTolerance = 5 * time.Minute
+
+var (
+	ErrWebhookTimestamp = errors.New("webhook timestamp is missing or outside of the tolerance")
+)
+
+// webhookBody returns the body, from StoreBodyInContext when it ran
+func webhookBody(r *http.Request) (string, error) {
+	body, err := readBody(r)
+	return string(body), err
+}
+
+func headerSignature(header string) func(r *http.Request) (string, error) {
+	return func(r *http.Request) (string, error) {
+		if value := r.Header.Get(header); value != "" {
+			return value, nil
+		}
+		return "", fmt.Errorf("header %s is missing", header)
+	}
+}
+
+func checkWebhookTimestamp(value string, tolerance time.Duration) error {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return ErrWebhookTimestamp
+	}
+	if tolerance == 0 {
+		tolerance = defaultWebhookTolerance
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > tolerance || age < -tolerance {
+		return ErrWebhookTimestamp
+	}
+	return nil
+}
+
+// VerifyGitHubWebhook checks the X-Hub-Signature-256 header of GitHub webhooks
+func VerifyGitHubWebhook(secret string) func(handler http.Handler) http.Handler {
+	method := signature.SigningMethodHMAC{Key: []byte(secret), HashMethod: crypto.SHA256, Encoding: signature.HexEncoding, Prefix: "sha256="}
+	return CheckSignature(method, webhookBody, headerSignature("X-Hub-Signature-256"))
+}
+
+// anySignature accepts a comma separated list of signatures when one of them
+// is valid, for providers sending a signature per active secret
+type anySignature struct {
+	signature.SigningMethod
+}
+
+func (method anySignature) Verify(signingString, signatures string) error {
+	for _, sig := range strings.Split(signatures, ",") {
+		if method.SigningMethod.Verify(signingString, sig) == nil {
+			return nil
+		}
+	}
+	return signature.ErrSignatureInvalid
+}
+
+// parseStripeSignature splits the Stripe-Signature header into its timestamp
+// and v1 signatures
+func parseStripeSignature(r *http.Request) (timestamp string, signatures []string) {
+	for _, part := range strings.Split(r.Header.Get("Stripe-Signature"), ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			signatures = append(signatures, kv[1])
+		}
+	}
+	return timestamp, signatures
+}
+
+// VerifyStripeWebhook checks the Stripe-Signature header and rejects events
+// whose timestamp is older than tolerance, 5 minutes when 0
+func VerifyStripeWebhook(secret string, tolerance time.Duration) func(handler http.Handler) http.Handler {
+	method := anySignature{signature.SigningMethodHMAC{Key: []byte(secret), HashMethod: crypto.SHA256, Encoding: signature.HexEncoding}}
+	makeSigningString := func(r *http.Request) (string, error) {
+		timestamp, _ := parseStripeSignature(r)
+		if err := checkWebhookTimestamp(timestamp, tolerance); err != nil {
+			return "", err
+		}
+		body, err := webhookBody(r)
+		if err != nil {
+			return "", err
+		}
+		return timestamp + "." + body, nil
+	}
+	getSignature := func(r *http.Request) (string, error) {
+		_, signatures := parseStripeSignature(r)
+		if len(signatures) == 0 {
+			return "", fmt.Errorf("header Stripe-Signature has no v1 signature")
+		}
+		return strings.Join(signatures, ","), nil
+	}
+	return CheckSignature(method, makeSigningString, getSignature)
+}
+
+// VerifySlackWebhook checks the v0 X-Slack-Signature header and rejects
+// requests whose X-Slack-Request-Timestamp is older than tolerance, 5
+// minutes when 0
+func VerifySlackWebhook(signingSecret string, tolerance time.Duration) func(handler http.Handler) http.Handler {
+	method := signature.SigningMethodHMAC{Key: []byte(signingSecret), HashMethod: crypto.SHA256, Encoding: signature.HexEncoding, Prefix: "v0="}
+	makeSigningString := func(r *http.Request) (string, error) {
+		timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+		if err := checkWebhookTimestamp(timestamp, tolerance); err != nil {
+			return "", err
+		}
+		body, err := webhookBody(r)
+		if err != nil {
+			return "", err
+		}
+		return "v0:" + timestamp + ":" + body, nil
+	}
+	return CheckSignature(method, makeSigningString, headerSignature("X-Slack-Signature"))
+}
+
+// VerifyShopifyWebhook checks the X-Shopify-Hmac-Sha256 header of Shopify
+// webhooks
+func VerifyShopifyWebhook(secret string) func(handler http.Handler) http.Handler {
+	method := signature.SigningMethodHMAC{Key: []byte(secret), HashMethod: crypto.SHA256, Encoding: base64.StdEncoding.Strict()}
+	return CheckSignature(method, webhookBody, headerSignature("X-Shopify-Hmac-Sha256"))
+}
+
+// VerifyTwilioWebhook checks the X-Twilio-Signature header. Twilio signs the
+// URL it called, publicURL rebuilds it from the request and defaults to the
+// scheme guessed from proxy headers, the Host header and the request URI.
+func VerifyTwilioWebhook(authToken string, publicURL func(r *http.Request) string) func(handler http.Handler) http.Handler {
+	if publicURL == nil {
+		publicURL = func(r *http.Request) string {
+			return guessScheme(r) + "://" + r.Host + r.URL.RequestURI()
+		}
+	}
+	method := signature.SigningMethodHMAC{Key: []byte(authToken), HashMethod: crypto.SHA1, Encoding: base64.StdEncoding.Strict()}
+	makeSigningString := func(r *http.Request) (string, error) {
+		signingString := publicURL(r)
+		body, err := webhookBody(r)
+		if err != nil {
+			return "", err
+		}
+
+		// JSON bodies are covered by the bodySHA256 query parameter
+		if bodyHash := r.URL.Query().Get("bodySHA256"); bodyHash != "" {
+			sum := sha256.Sum256([]byte(body))
+			if !strings.EqualFold(bodyHash, hex.EncodeToString(sum[:])) {
+				return "", fmt.Errorf("bodySHA256 does not match the body")
+			}
+			return signingString, nil
+		}
+
+		if r.Method != http.MethodPost || !isFormRequest(r) {
+			return signingString, nil
+		}
+		form, err := url.ParseQuery(body)
+		if err != nil {
+			return "", err
+		}
+		keys := make([]string, 0, len(form))
+		for key := range form {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		var b strings.Builder
+		b.WriteString(signingString)
+		for _, key := range keys {
+			values := form[key]
+			sort.Strings(values)
+			for _, value := range values {
+				b.WriteString(key)
+				b.WriteString(value)
+			}
+		}
+		return b.String(), nil
+	}
+	return CheckSignature(method, makeSigningString, headerSignature("X-Twilio-Signature"))
+}
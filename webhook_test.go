@@ -0,0 +1,178 @@
+package middlewares
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// webhookHandler returns handler behind middleware and a pointer to the body
+// it read
+func webhookHandler(middleware func(http.Handler) http.Handler) (http.Handler, *string) {
+	var body string
+	return middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, _ := ioutil.ReadAll(r.Body)
+		body = string(content)
+	})), &body
+}
+
+func webhookRequest(target, body string, headers map[string]string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	return r
+}
+
+func hmacHex(secret, message string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyGitHubWebhook(t *testing.T) {
+	// from "Validating webhook deliveries" of the GitHub documentation
+	handler, body := webhookHandler(VerifyGitHubWebhook("It's a Secret to Everybody"))
+	sign := map[string]string{"X-Hub-Signature-256": "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"}
+
+	if code := serve(handler, webhookRequest("/", "Hello, World!", sign)); code != http.StatusOK {
+		t.Fatalf("documented delivery: status %d", code)
+	}
+	if *body != "Hello, World!" {
+		t.Errorf("handler read %q", *body)
+	}
+	if code := serve(handler, webhookRequest("/", "Hello, World?", sign)); code != http.StatusUnauthorized {
+		t.Errorf("tampered body: status %d", code)
+	}
+	if code := serve(handler, webhookRequest("/", "Hello, World!", nil)); code != http.StatusUnauthorized {
+		t.Errorf("unsigned delivery: status %d", code)
+	}
+	legacy := map[string]string{"X-Hub-Signature-256": "757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"}
+	if code := serve(handler, webhookRequest("/", "Hello, World!", legacy)); code != http.StatusUnauthorized {
+		t.Errorf("signature without prefix: status %d", code)
+	}
+}
+
+func TestVerifyStripeWebhook(t *testing.T) {
+	handler, _ := webhookHandler(VerifyStripeWebhook("whsec_test", 0))
+	payload := `{"id": "evt_1", "type": "charge.succeeded"}`
+	stripeRequest := func(timestamp int64, body string, signatures ...string) *http.Request {
+		header := "t=" + strconv.FormatInt(timestamp, 10)
+		for _, sign := range signatures {
+			header += ",v1=" + sign
+		}
+		return webhookRequest("/", body, map[string]string{"Stripe-Signature": header})
+	}
+	now := time.Now().Unix()
+	sign := func(timestamp int64, secret string) string {
+		return hmacHex(secret, strconv.FormatInt(timestamp, 10)+"."+payload)
+	}
+
+	if code := serve(handler, stripeRequest(now, payload, sign(now, "whsec_test"))); code != http.StatusOK {
+		t.Fatalf("signed event: status %d", code)
+	}
+	// during a secret roll Stripe signs with both secrets
+	if code := serve(handler, stripeRequest(now, payload, sign(now, "whsec_old"), sign(now, "whsec_test"))); code != http.StatusOK {
+		t.Errorf("event signed with two secrets: status %d", code)
+	}
+	if code := serve(handler, stripeRequest(now, `{"id": "evt_2"}`, sign(now, "whsec_test"))); code != http.StatusUnauthorized {
+		t.Errorf("tampered body: status %d", code)
+	}
+	if code := serve(handler, stripeRequest(now+1, payload, sign(now, "whsec_test"))); code != http.StatusUnauthorized {
+		t.Errorf("tampered timestamp: status %d", code)
+	}
+	old := now - 600
+	if code := serve(handler, stripeRequest(old, payload, sign(old, "whsec_test"))); code != http.StatusUnauthorized {
+		t.Errorf("replayed event: status %d", code)
+	}
+}
+
+func TestVerifySlackWebhook(t *testing.T) {
+	// from "Verifying requests from Slack", signed in 2018, hence the tolerance
+	const payload = "token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&team_domain=testteamnow&channel_id=G8PSS9T3V&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner&command=%2Fwebhook-collect&text=&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c"
+	headers := map[string]string{
+		"X-Slack-Request-Timestamp": "1531420618",
+		"X-Slack-Signature":         "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503",
+	}
+	handler, _ := webhookHandler(VerifySlackWebhook("8f742231b10e8888abcd99yyyzzz85a5", 100*365*24*time.Hour))
+	if code := serve(handler, webhookRequest("/", payload, headers)); code != http.StatusOK {
+		t.Fatalf("documented request: status %d", code)
+	}
+	if code := serve(handler, webhookRequest("/", strings.Replace(payload, "roadrunner", "coyote", 1), headers)); code != http.StatusUnauthorized {
+		t.Errorf("tampered body: status %d", code)
+	}
+
+	handler, _ = webhookHandler(VerifySlackWebhook("8f742231b10e8888abcd99yyyzzz85a5", 0))
+	if code := serve(handler, webhookRequest("/", payload, headers)); code != http.StatusUnauthorized {
+		t.Errorf("replayed request: status %d", code)
+	}
+}
+
+func TestVerifyShopifyWebhook(t *testing.T) {
+	handler, _ := webhookHandler(VerifyShopifyWebhook("shpss_test"))
+	payload := `{"id": 820982911946154508}`
+	mac := hmac.New(sha256.New, []byte("shpss_test"))
+	mac.Write([]byte(payload))
+	sign := map[string]string{"X-Shopify-Hmac-Sha256": base64.StdEncoding.EncodeToString(mac.Sum(nil))}
+
+	if code := serve(handler, webhookRequest("/", payload, sign)); code != http.StatusOK {
+		t.Fatalf("signed webhook: status %d", code)
+	}
+	if code := serve(handler, webhookRequest("/", `{"id": 820982911946154509}`, sign)); code != http.StatusUnauthorized {
+		t.Errorf("tampered body: status %d", code)
+	}
+}
+
+func TestVerifyTwilioWebhook(t *testing.T) {
+	// from "Webhooks security" of the Twilio documentation
+	form := url.Values{
+		"CallSid": {"CA1234567890ABCDE"},
+		"Caller":  {"+12349013030"},
+		"Digits":  {"1234"},
+		"From":    {"+12349013030"},
+		"To":      {"+18005551212"},
+	}
+	twilioRequest := func(form url.Values, sign string) *http.Request {
+		r := webhookRequest("https://mycompany.com/myapp.php?foo=1&bar=2", form.Encode(), map[string]string{
+			"Content-Type":       "application/x-www-form-urlencoded",
+			"X-Twilio-Signature": sign,
+		})
+		r.Host = "mycompany.com"
+		return r
+	}
+	const documented = "0/KCTR6DLpKmkAf8muzZqo1nDgQ="
+
+	handler, _ := webhookHandler(VerifyTwilioWebhook("12345", nil))
+	if code := serve(handler, twilioRequest(form, documented)); code != http.StatusOK {
+		t.Fatalf("documented request: status %d", code)
+	}
+
+	tampered := url.Values{}
+	for k, v := range form {
+		tampered[k] = v
+	}
+	tampered.Set("Digits", "0000")
+	if code := serve(handler, twilioRequest(tampered, documented)); code != http.StatusUnauthorized {
+		t.Errorf("tampered parameter: status %d", code)
+	}
+
+	// behind a proxy the public URL differs from the one received
+	behindProxy := VerifyTwilioWebhook("12345", func(r *http.Request) string {
+		return "https://mycompany.com/myapp.php?foo=1&bar=2"
+	})
+	handler, _ = webhookHandler(behindProxy)
+	r := twilioRequest(form, documented)
+	r.Host = "internal:8080"
+	if code := serve(handler, r); code != http.StatusOK {
+		t.Errorf("request behind a proxy: status %d", code)
+	}
+}
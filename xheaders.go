@@ -1,6 +1,11 @@
 package middlewares
 
-import "net/http"
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
 
 func guessRealIP(r *http.Request) string {
 	for _, key := range []string{"X-Real-IP", "X-Forwarded-For"} {
@@ -34,3 +39,52 @@ func ParseProxyHeaders(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// TrustedProxyClientIP returns a function finding the client IP of requests
+// sent through the given proxies, as IPs or CIDRs. The nearest address of
+// X-Forwarded-For that is not a trusted proxy is the client, requests that
+// don't come from a trusted proxy use the host of RemoteAddr.
+func TrustedProxyClientIP(proxies ...string) func(r *http.Request) string {
+	trusted := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if strings.Contains(proxy, ":") {
+				proxy += "/128"
+			} else {
+				proxy += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			panic(fmt.Sprintf("middlewares: invalid trusted proxy %q: %v", proxy, err))
+		}
+		trusted = append(trusted, network)
+	}
+	isTrusted := func(addr string) bool {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return false
+		}
+		for _, network := range trusted {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(r *http.Request) string {
+		remote := clientIP(r)
+		if !isTrusted(remote) {
+			return remote
+		}
+		hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop != "" && !isTrusted(hop) {
+				return hop
+			}
+		}
+		return remote
+	}
+}